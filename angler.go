@@ -1,60 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"slices"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-const SecondsToDays int64 = 60 * 60 * 24
-
-func GetTodayAngleIssue() int {
-	// Angle Issue and timestamp at 7am GMT-5 on July 1 2025
-	referenceIssue := 1106
-	referenceUnixTime := 1751371200
-
-	currentUnixTime := time.Now().Unix()
-	timeDiff := (currentUnixTime - int64(referenceUnixTime)) / SecondsToDays
-	currentAngleIssue := referenceIssue + int(timeDiff)
-	return currentAngleIssue
-}
-
-func GetCurrentSeason() int {
-	a := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
-	b := time.Now().UTC()
-
-	if a.After(b) {
-		return 0
-	}
-
-	y1, M1, d1 := a.Date()
-	y2, M2, d2 := b.Date()
-
-	year := int(y2 - y1)
-	month := int(M2 - M1)
-	day := int(d2 - d1)
-	if day < 0 {
-		// days in month:
-		t := time.Date(y1, M1, 32, 0, 0, 0, 0, time.UTC)
-		day += 32 - t.Day()
-		month--
-	}
-
-	if month < 0 {
-		month += 12
-		year--
-	}
-
-	season := year*12 + month + 1
-	return season
-}
-
 func GetFailQuotesListMessage(guildId string) string {
-	failQuotes := ListFailQuotes(guildId)
+	failQuotes, err := store.ListFailQuotes(guildId)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the fail quotes, try again"
+	}
 	message := ""
 	for i, failQuote := range failQuotes {
 		message += fmt.Sprintf("%d. %s\n", i+1, failQuote.Quote)
@@ -62,7 +25,7 @@ func GetFailQuotesListMessage(guildId string) string {
 	return message
 }
 
-func GetFailQuoteActionResultMessage(message string, guildId string) string {
+func GetFailQuoteActionResultMessage(message string, guildId string, authorId string) string {
 	command := strings.Split(message, " ")
 
 	if len(command) == 1 {
@@ -88,14 +51,22 @@ func GetFailQuoteActionResultMessage(message string, guildId string) string {
 
 	if action == "add" {
 		fullQuote := strings.Join(command[2:], " ")
-		InsertFailQuote(guildId, fullQuote)
+		err := store.InsertFailQuote(guildId, fullQuote, authorId)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't save the quote, try again"
+		}
 		message := fmt.Sprintf("Added quote '%s'\n", fullQuote)
 		message += GetFailQuotesListMessage(guildId)
 		return message
 	}
 
 	if action == "remove" {
-		failQuotes := ListFailQuotes(guildId)
+		failQuotes, err := store.ListFailQuotes(guildId)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't load the fail quotes, try again"
+		}
 		pos, err := strconv.Atoi(input)
 		if err != nil {
 			return fmt.Sprintf("Cant convert %s to an integer", input)
@@ -105,14 +76,15 @@ func GetFailQuoteActionResultMessage(message string, guildId string) string {
 			return "The position of the quote to remove should be greater than 0"
 		}
 
-		if pos-1 > len(failQuotes) {
+		if pos > len(failQuotes) {
 			return "The position is greater than the total lenght of quotes"
 		}
 
 		failQuoteId := failQuotes[pos-1].Id
-		err = RemoveFailQuote(failQuoteId, guildId)
+		err = store.RemoveFailQuote(failQuoteId, guildId, authorId)
 		if err != nil {
-			return err.Error()
+			log.Println(err)
+			return "Couldn't remove the quote, try again"
 		}
 		message := fmt.Sprintf("Removed quote '%s' from the list\n", failQuotes[pos-1].Quote)
 		message += GetFailQuotesListMessage(guildId)
@@ -122,16 +94,93 @@ func GetFailQuoteActionResultMessage(message string, guildId string) string {
 	return ""
 }
 
-func GetStandingMessage(message string) string {
+// parseCommandArgs splits a command into its optional game name and the rest
+// of its arguments, leaving out user mentions.
+func parseCommandArgs(message string) (string, []string) {
+	game := AngleGame
+	args := []string{}
+	for _, arg := range strings.Fields(message)[1:] {
+		if strings.HasPrefix(arg, "<@") {
+			continue
+		}
+		if gameParser, ok := GetGameParser(arg); ok {
+			game = gameParser.Name()
+			continue
+		}
+		args = append(args, arg)
+	}
+	return game, args
+}
+
+func GetSettingsMessage(s *discordgo.Session, m *discordgo.MessageCreate) string {
+	command := strings.Fields(m.Content)
+	if len(command) == 1 {
+		return fmt.Sprintf(`!settings changes how the bot works in this server
+-------------
+!settings %s <%s> - What to do when someone posts more than one result for the same day, currently %s
+!settings %s <#channel|off> - Where to send the daily reminders`, DuplicatePolicySetting, strings.Join(duplicatePolicies, "|"), GetDuplicatePolicy(m.GuildID), ReminderChannelSetting)
+	}
+
+	if !IsAdmin(s, m.Message) {
+		return "Only admins can change settings"
+	}
+
+	if len(command) < 3 {
+		return fmt.Sprintf("Setting %s requires a value", command[1])
+	}
+	setting, value := command[1], command[2]
+
+	switch setting {
+	case DuplicatePolicySetting:
+		if !slices.Contains(duplicatePolicies, value) {
+			return fmt.Sprintf("%s is not a valid policy, use one of %s", value, strings.Join(duplicatePolicies, ", "))
+		}
+	case ReminderChannelSetting:
+		if value != "off" {
+			channel, err := s.Channel(parseChannelMention(value))
+			if err != nil || channel.GuildID != m.GuildID {
+				return fmt.Sprintf("%s is not a channel of this server", value)
+			}
+			value = channel.ID
+		}
+	default:
+		return fmt.Sprintf("Unknown setting %s", setting)
+	}
+
+	err := store.SetGuildSetting(m.GuildID, setting, value, m.Author.ID)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't save the setting, try again"
+	}
+	return fmt.Sprintf("Setting %s is now %s", setting, value)
+}
+
+func GetStandingMessage(message string, guildId string) string {
+	current, err := GetCurrentSeason(guildId)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the seasons, try again"
+	}
 	allSeasons := false
-	season := GetCurrentSeason()
-	command := strings.Split(message, " ")
+	season := current.Number
+	perGame := false
+	game, command := parseCommandArgs(message)
 	var standingMessage string
-	var err error
-	if len(command) > 1 {
-		seasonStr := command[1]
+	if slices.Contains(command, "pergame") {
+		perGame = true
+		command = slices.DeleteFunc(command, func(arg string) bool { return arg == "pergame" })
+	}
+	if len(command) > 0 {
+		seasonStr := command[0]
 		if seasonStr == "all" {
 			allSeasons = true
+		} else if seasonStr == "rating" {
+			standingMessage, err = GetRatingStandings(guildId, game)
+			if err != nil {
+				log.Println(err)
+				return "Couldn't load the ratings, try again"
+			}
+			return standingMessage
 		} else {
 			season, err = strconv.Atoi(seasonStr)
 			if err != nil {
@@ -142,22 +191,30 @@ func GetStandingMessage(message string) string {
 
 	if season < 1 {
 		return "Seasons starts at 1!!"
-	} else if season > GetCurrentSeason() {
-		return fmt.Sprintf("We are only on season %d", season)
+	} else if season > current.Number {
+		return fmt.Sprintf("We are only on season %d", current.Number)
 	}
 
-	standingMessage = GetStandings(season, allSeasons)
+	standingMessage, err = GetStandings(guildId, game, season, allSeasons, perGame)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the standings, try again"
+	}
 	return standingMessage
 }
 
-func GetStatsMessage(message string, userId string) string {
+func GetStatsMessage(message string, guildId string, userId string) string {
+	current, err := GetCurrentSeason(guildId)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the seasons, try again"
+	}
 	allSeasons := false
-	season := GetCurrentSeason()
-	command := strings.Split(message, " ")
+	season := current.Number
+	game, command := parseCommandArgs(message)
 	var statsMessage string
-	var err error
-	if len(command) > 1 {
-		seasonStr := command[1]
+	if len(command) > 0 {
+		seasonStr := command[0]
 		if seasonStr == "all" {
 			allSeasons = true
 		} else {
@@ -170,72 +227,235 @@ func GetStatsMessage(message string, userId string) string {
 
 	if season < 1 {
 		return "Seasons starts at 1!!"
-	} else if season > GetCurrentSeason() {
-		return fmt.Sprintf("We are only on season %d", season)
+	} else if season > current.Number {
+		return fmt.Sprintf("We are only on season %d", current.Number)
 	}
 
-	statsMessage = GetStats(userId, season, allSeasons)
+	statsMessage, err = store.GetStats(guildId, userId, game, season, allSeasons)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the stats, try again"
+	}
 	return statsMessage
 }
 
-func ParseAngleEntry(message string, authorId string, authorName string) AngleEntry {
-	lines := strings.Split(message, "\n")
-	firstLineValues := strings.Split(lines[0], " ")
-	angleNumber, _ := strconv.Atoi(firstLineValues[1][1:])
-	numberOfTriesStr := firstLineValues[2][0]
+var (
+	ErrMalformedHeader  = errors.New("malformed header")
+	ErrMissingDistance  = errors.New("missing distance line")
+	ErrTriesOutOfRange  = errors.New("tries out of range")
+	ErrIssueInTheFuture = errors.New("issue in the future")
+)
+
+const MaxAngleTries = 4
+
+func ParseAngleEntry(message string, authorId string, authorName string) (AngleEntry, error) {
+	lines := strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n")
+	firstLineValues := strings.Fields(lines[0])
+	if len(firstLineValues) < 3 || firstLineValues[0] != "#Angle" || !strings.HasPrefix(firstLineValues[1], "#") {
+		return AngleEntry{}, fmt.Errorf("%w: %q", ErrMalformedHeader, lines[0])
+	}
+
+	angleNumber, err := strconv.Atoi(firstLineValues[1][1:])
+	if err != nil || angleNumber < 1 {
+		return AngleEntry{}, fmt.Errorf("%w: invalid issue %q", ErrMalformedHeader, firstLineValues[1])
+	}
+	if err := validateIssue(angleNumber, GetTodayAngleIssue()); err != nil {
+		return AngleEntry{}, err
+	}
+
+	numberOfTriesStr, _, found := strings.Cut(firstLineValues[2], "/")
+	if !found {
+		return AngleEntry{}, fmt.Errorf("%w: invalid tries %q", ErrMalformedHeader, firstLineValues[2])
+	}
 	completed := 1
-	if numberOfTriesStr == 'X' {
+	numberOfTries := MaxAngleTries
+	if numberOfTriesStr == "X" {
 		completed = 0
-		numberOfTriesStr = '4'
+	} else {
+		numberOfTries, err = strconv.Atoi(numberOfTriesStr)
+		if err != nil {
+			return AngleEntry{}, fmt.Errorf("%w: invalid tries %q", ErrMalformedHeader, firstLineValues[2])
+		}
+		if numberOfTries < 1 || numberOfTries > MaxAngleTries {
+			return AngleEntry{}, fmt.Errorf("%w: %d", ErrTriesOutOfRange, numberOfTries)
+		}
+	}
+
+	if len(lines) < 2 || strings.TrimSpace(lines[1]) == "" {
+		return AngleEntry{}, ErrMissingDistance
 	}
 
-	numberOfTries, _ := strconv.Atoi(string(numberOfTriesStr))
+	guesses := parseAngleGuesses(lines[1:])
 
-	secondLineValues := strings.Split(lines[1], " ")
+	secondLineValues := strings.Fields(lines[1])
 	angleOff := 0
 	// Did not complete
-	if len(secondLineValues) > 1 {
+	if completed == 0 {
+		if len(secondLineValues) < 2 {
+			return AngleEntry{}, fmt.Errorf("%w: no distance in %q", ErrMissingDistance, lines[1])
+		}
 		angleOffStr := strings.Split(secondLineValues[1], "°")
-		angleOff, _ = strconv.Atoi(angleOffStr[0])
+		angleOff, err = strconv.Atoi(angleOffStr[0])
+		if err != nil {
+			return AngleEntry{}, fmt.Errorf("%w: invalid distance %q", ErrMissingDistance, secondLineValues[1])
+		}
+	}
+	angleEntry := AngleEntry{Game: AngleGame, UserId: authorId, GlobalName: authorName, AngleIssue: angleNumber, Tries: numberOfTries, OffBy: angleOff, Completed: completed, Guesses: guesses}
+	return angleEntry, nil
+}
+
+var guessDirections = map[rune]string{
+	'⬆': GuessUp,
+	'⬇': GuessDown,
+	'🎉': GuessHit,
+	'✅': GuessHit,
+}
+
+// parseAngleGuesses reads the guess trail below the share text header. The
+// trail can come as a single line of arrows or as one line per guess, in both
+// cases a degree offset belongs to the last guess on its line.
+func parseAngleGuesses(lines []string) []AngleGuess {
+	guesses := []AngleGuess{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "http") {
+			continue
+		}
+
+		lineGuesses := []AngleGuess{}
+		for _, r := range line {
+			if direction, ok := guessDirections[r]; ok {
+				guess := AngleGuess{Number: len(guesses) + len(lineGuesses) + 1, Direction: direction, OffBy: -1}
+				if direction == GuessHit {
+					guess.OffBy = 0
+				}
+				lineGuesses = append(lineGuesses, guess)
+			}
+		}
+		if len(lineGuesses) == 0 {
+			continue
+		}
+
+		for _, value := range strings.Fields(line) {
+			angleOffStr, _, found := strings.Cut(value, "°")
+			if !found {
+				continue
+			}
+			if angleOff, err := strconv.Atoi(strings.TrimLeft(angleOffStr, ":")); err == nil {
+				lineGuesses[len(lineGuesses)-1].OffBy = angleOff
+			}
+		}
+		guesses = append(guesses, lineGuesses...)
 	}
-	angleEntry := AngleEntry{UserId: authorId, GlobalName: authorName, AngleIssue: angleNumber, Tries: numberOfTries, OffBy: angleOff, Completed: completed}
-	return angleEntry
+	return guesses
 }
 
-func GetEntryEmojiReaction(completed int, numberOfTries int, m *discordgo.MessageCreate) string {
+func validateIssue(issue int, todayIssue int) error {
+	if issue > todayIssue {
+		return fmt.Errorf("%w: issue %d, today is %d", ErrIssueInTheFuture, issue, todayIssue)
+	}
+	return nil
+}
+
+func GetParseErrorMessage(game GameParser, err error) string {
+	switch {
+	case errors.Is(err, ErrMalformedHeader):
+		return fmt.Sprintf("I couldn't read that result, the first line should look like `%s`. Please paste the share text as it is.", game.Example())
+	case errors.Is(err, ErrMissingDistance):
+		return "That result is missing the line with your guesses and how far off you were. Please paste the whole share text."
+	case errors.Is(err, ErrTriesOutOfRange):
+		return fmt.Sprintf("That result has an invalid number of tries, it should be between 1 and %d or X.", game.MaxTries())
+	case errors.Is(err, ErrIssueInTheFuture):
+		return fmt.Sprintf("That %s isn't out yet, today's is #%d.", game.Name(), game.TodayIssue())
+	}
+	return "I couldn't read that result."
+}
+
+func GetEntryEmojiReaction(completed int, numberOfTries int) string {
 	emojiId := ""
 	if completed == 0 {
 		emojiId = "😭"
-		failQuotes := ListFailQuotes(m.GuildID)
-		randomQuote := failQuotes[rand.Intn(len(failQuotes))].Quote
-		message := fmt.Sprintf("<@%s> %s", m.Author.ID, randomQuote)
-		s.ChannelMessageSend(m.ChannelID, message)
 	} else if numberOfTries == 1 {
 		emojiId = "<:emoji_22:1383877615613509715>"
 	} else if numberOfTries == 2 {
 		emojiId = "🥳"
 	} else if numberOfTries == 3 {
 		emojiId = "👍"
-	} else if numberOfTries == 4 {
+	} else if numberOfTries >= 4 {
 		emojiId = "😢"
 	}
 
 	return emojiId
 }
 
-func GetSeasonWinCount() string {
+func SendFailQuote(m *discordgo.Message) {
+	failQuotes, err := store.ListFailQuotes(m.GuildID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(failQuotes) == 0 {
+		return
+	}
+	randomQuote := failQuotes[rand.Intn(len(failQuotes))].Quote
+	message := fmt.Sprintf("<@%s> %s", m.Author.ID, randomQuote)
+	s.ChannelMessageSend(m.ChannelID, message)
+}
+
+func GetTransportadorMessage(guildId string, userId string) string {
+	oneGuessEntries, err := store.CountOneGuessEntries(guildId, userId)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't count the transportadores, try again"
+	}
+	return fmt.Sprintf("%s ha usado el transportador <:emoji_22:1383877615613509715> %d veces\n", GetDisplayName(guildId, userId), oneGuessEntries)
+}
+
+// GetSeasonWinCount counts the seasons won by each user, users are counted by
+// their id so renaming doesn't split their wins. Co-champions each get the win.
+func GetSeasonWinCount(command string, guildId string) string {
+	game, _ := parseCommandArgs(command)
+	seasons, err := store.ListSeasons(guildId)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the seasons, try again"
+	}
 	seasonWins := map[string]int{}
-	for season := 1; season < GetCurrentSeason(); season++ {
-		winner := GetSeasonWinner(season).User
-		if _, ok := seasonWins[winner]; ok {
-			seasonWins[winner] += 1
-		} else {
-			seasonWins[winner] = 1
+	todayIssue := GetTodayAngleIssue()
+	current := currentSeason(seasons, todayIssue)
+	for _, season := range seasons {
+		// Only seasons that are over have a winner, the last custom season
+		// goes on after its end
+		if season.EndIssue >= todayIssue || season.Number == current.Number {
+			continue
+		}
+		winners, err := GetSeasonWinners(guildId, game, season.Number)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't load the season winners, try again"
+		}
+		for _, winner := range winners {
+			seasonWins[winner.Id] += 1
 		}
 	}
-	message := "🍔🍔🍔🥂\n"
+	type seasonWinCount struct {
+		User string
+		Wins int
+	}
+	winCounts := []seasonWinCount{}
 	for k, v := range seasonWins {
-		message += fmt.Sprintf("%s: %d\n", k, v)
+		winCounts = append(winCounts, seasonWinCount{User: GetDisplayName(guildId, k), Wins: v})
+	}
+	slices.SortFunc(winCounts, func(a seasonWinCount, b seasonWinCount) int {
+		if a.Wins != b.Wins {
+			return b.Wins - a.Wins
+		}
+		return strings.Compare(a.User, b.User)
+	})
+
+	message := "🍔🍔🍔🥂\n"
+	for _, winCount := range winCounts {
+		message += fmt.Sprintf("%s: %d\n", winCount.User, winCount.Wins)
 	}
 	return message
 }
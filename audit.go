@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditEntryInsert   = "entry_insert"
+	AuditEntryUpdate   = "entry_update"
+	AuditEntryRetract  = "entry_retract"
+	AuditQuoteAdd      = "quote_add"
+	AuditQuoteRemove   = "quote_remove"
+	AuditSettingChange = "setting_change"
+	// Changes made to many entries at once, recorded once per guild
+	AuditSeasonRecompute = "season_recompute"
+	AuditGuildAssign     = "guild_assign"
+)
+
+const auditPageSize = 10
+
+// AuditEntry is a change made to the data of a guild. ActorId is empty when
+// it isn't known who made it, like when a message is deleted, or when the bot
+// made it on its own.
+type AuditEntry struct {
+	Id        string
+	GuildId   string
+	ActorId   string
+	Action    string
+	TargetId  string
+	Before    string
+	After     string
+	CreatedAt time.Time
+}
+
+// auditAngleEntry is how entries are written in the before and after values
+type auditAngleEntry struct {
+	Game      string `json:"game"`
+	Issue     int    `json:"issue"`
+	Tries     int    `json:"tries"`
+	OffBy     int    `json:"off_by"`
+	Completed int    `json:"completed"`
+}
+
+func auditAngleEntryValue(angleEntry AngleEntry) string {
+	value, _ := json.Marshal(auditAngleEntry{Game: angleEntry.Game, Issue: angleEntry.AngleIssue, Tries: angleEntry.Tries, OffBy: angleEntry.OffBy, Completed: angleEntry.Completed})
+	return string(value)
+}
+
+// getAuditAngleEntry reads the entry as it is before changing it, with the
+// fields written to the audit log and its guild.
+func getAuditAngleEntry(tx *sqlTx, entryId string) (AngleEntry, error) {
+	angleEntry := AngleEntry{}
+	err := tx.QueryRow("select coalesce(guild_id, ''), game, angle_issue, tries, off_by, completed from angle_tries where id = ?", entryId).Scan(&angleEntry.GuildId, &angleEntry.Game, &angleEntry.AngleIssue, &angleEntry.Tries, &angleEntry.OffBy, &angleEntry.Completed)
+	if err != nil {
+		return AngleEntry{}, fmt.Errorf("Error reading entry %s: %w", entryId, err)
+	}
+	return angleEntry, nil
+}
+
+// insertAuditLog records a change in the same transaction that makes it.
+func insertAuditLog(tx *sqlTx, auditEntry AuditEntry) error {
+	_, err := tx.Exec("insert into audit_log(id, guild_id, actor_id, action, target_id, before_value, after_value, created_at) values(?, ?, ?, ?, ?, ?, ?, ?)", uuid.NewString(), auditEntry.GuildId, auditEntry.ActorId, auditEntry.Action, auditEntry.TargetId, auditEntry.Before, auditEntry.After, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("Error writing %s to the audit log: %w", auditEntry.Action, err)
+	}
+	return nil
+}
+
+// ListAuditLog returns the changes made in a guild, newest first.
+func (st *Store) ListAuditLog(guildId string, limit int, offset int) ([]AuditEntry, error) {
+	rows, err := st.db.Query("select id, guild_id, actor_id, action, target_id, coalesce(before_value, ''), coalesce(after_value, ''), created_at from audit_log where guild_id = ? order by created_at desc, rowid desc limit ? offset ?", guildId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading audit log of guild %s: %w", guildId, err)
+	}
+	defer rows.Close()
+
+	auditEntries := []AuditEntry{}
+	for rows.Next() {
+		auditEntry := AuditEntry{}
+		var createdAt int64
+		err = rows.Scan(&auditEntry.Id, &auditEntry.GuildId, &auditEntry.ActorId, &auditEntry.Action, &auditEntry.TargetId, &auditEntry.Before, &auditEntry.After, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		auditEntry.CreatedAt = time.Unix(createdAt, 0)
+		auditEntries = append(auditEntries, auditEntry)
+	}
+	return auditEntries, rows.Err()
+}
+
+func describeAuditAngleEntry(value string) string {
+	angleEntry := auditAngleEntry{}
+	err := json.Unmarshal([]byte(value), &angleEntry)
+	if err != nil {
+		return value
+	}
+	result := fmt.Sprintf("%d tries", angleEntry.Tries)
+	if angleEntry.Completed == 0 {
+		result = "failed"
+	}
+	return fmt.Sprintf("%s #%d %s", angleEntry.Game, angleEntry.Issue, result)
+}
+
+func describeAuditEntry(auditEntry AuditEntry) string {
+	switch auditEntry.Action {
+	case AuditEntryInsert:
+		return fmt.Sprintf("posted %s", describeAuditAngleEntry(auditEntry.After))
+	case AuditEntryUpdate:
+		return fmt.Sprintf("edited %s to %s", describeAuditAngleEntry(auditEntry.Before), describeAuditAngleEntry(auditEntry.After))
+	case AuditEntryRetract:
+		return fmt.Sprintf("retracted %s", describeAuditAngleEntry(auditEntry.Before))
+	case AuditQuoteAdd:
+		return fmt.Sprintf("added quote '%s'", auditEntry.After)
+	case AuditQuoteRemove:
+		return fmt.Sprintf("removed quote '%s'", auditEntry.Before)
+	case AuditScoringChange:
+		rules := ScoringRules{}
+		if err := json.Unmarshal([]byte(auditEntry.After), &rules); err != nil {
+			return fmt.Sprintf("changed the scoring from season %s", auditEntry.TargetId)
+		}
+		return fmt.Sprintf("changed the scoring from season %s to %s", auditEntry.TargetId, rules)
+	case AuditParticipantJoin:
+		return fmt.Sprintf("started playing %s from #%s", auditEntry.TargetId, auditEntry.After)
+	case AuditParticipantLeave:
+		return fmt.Sprintf("stopped playing %s from #%s", auditEntry.TargetId, auditEntry.After)
+	case AuditSeasonChange:
+		if auditEntry.Before == "" {
+			return fmt.Sprintf("added %s", auditEntry.After)
+		}
+		return fmt.Sprintf("changed %s to %s", auditEntry.Before, auditEntry.After)
+	case AuditSeasonRecompute:
+		return fmt.Sprintf("recomputed the season of %s", auditEntry.After)
+	case AuditGuildAssign:
+		return fmt.Sprintf("moved %s stored without a server here", auditEntry.After)
+	case AuditSettingChange:
+		if auditEntry.Before == "" {
+			return fmt.Sprintf("set %s to %s", auditEntry.TargetId, auditEntry.After)
+		}
+		return fmt.Sprintf("changed %s from %s to %s", auditEntry.TargetId, auditEntry.Before, auditEntry.After)
+	}
+	return auditEntry.Action
+}
+
+func GetAuditMessage(s *discordgo.Session, m *discordgo.MessageCreate) string {
+	if !IsAdmin(s, m.Message) {
+		return "Only admins can see the audit log"
+	}
+
+	page := 1
+	command := strings.Fields(m.Content)
+	if len(command) > 1 {
+		var err error
+		page, err = strconv.Atoi(command[1])
+		if err != nil || page < 1 {
+			return fmt.Sprintf("%s is not a valid page", command[1])
+		}
+	}
+
+	auditEntries, err := store.ListAuditLog(m.GuildID, auditPageSize, (page-1)*auditPageSize)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the audit log, try again"
+	}
+	if len(auditEntries) == 0 {
+		return "Nothing in the audit log"
+	}
+
+	message := fmt.Sprintf("Audit log, page %d\n", page)
+	for _, auditEntry := range auditEntries {
+		actor := "Someone"
+		if auditEntry.ActorId != "" {
+			actor = GetDisplayName(m.GuildID, auditEntry.ActorId)
+		}
+		message += fmt.Sprintf("%s %s %s\n", auditEntry.CreatedAt.UTC().Format("2006-01-02 15:04"), actor, describeAuditEntry(auditEntry))
+	}
+	if len(auditEntries) == auditPageSize {
+		message += fmt.Sprintf("!audit %d for older changes", page+1)
+	}
+	return message
+}
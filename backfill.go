@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const channelMessagesPageSize = 100
+
+type BackfillReport struct {
+	Imported int
+	Skipped  int
+	Rejected int
+}
+
+func (r BackfillReport) String() string {
+	return fmt.Sprintf("Imported %d results, skipped %d already stored and rejected %d", r.Imported, r.Skipped, r.Rejected)
+}
+
+// BackfillChannel pages through the whole history of a channel and stores
+// every result that isn't stored yet. Entries are matched by message id, so
+// running it again only picks up what is still missing.
+func BackfillChannel(s *discordgo.Session, channelId string) (BackfillReport, error) {
+	report := BackfillReport{}
+	channel, err := s.Channel(channelId)
+	if err != nil {
+		return report, fmt.Errorf("Error fetching channel %s: %w", channelId, err)
+	}
+
+	// Messages come newest first, they are all fetched before storing them so
+	// the duplicate policy sees them in the order they were posted
+	history := []*discordgo.Message{}
+	beforeId := ""
+	for {
+		messages, err := s.ChannelMessages(channelId, channelMessagesPageSize, beforeId, "", "")
+		if err != nil {
+			return report, fmt.Errorf("Error fetching messages of channel %s: %w", channelId, err)
+		}
+		history = append(history, messages...)
+		if len(messages) < channelMessagesPageSize {
+			break
+		}
+		beforeId = messages[len(messages)-1].ID
+	}
+	slices.Reverse(history)
+
+	for _, m := range history {
+		if m.Author == nil || m.Author.Bot {
+			continue
+		}
+		game, ok := DetectGame(m.Content)
+		if !ok {
+			continue
+		}
+
+		exists, err := store.AngleEntryMessageExists(m.ID)
+		if err != nil {
+			return report, err
+		}
+		if exists {
+			report.Skipped++
+			continue
+		}
+
+		angleEntry, err := game.Parse(m.Content, m.Author.ID, m.Author.GlobalName)
+		if err != nil {
+			log.Printf("Rejected %s entry from %s in message %s: %v", game.Name(), m.Author.ID, m.ID, err)
+			report.Rejected++
+			continue
+		}
+		angleEntry.MessageId = m.ID
+		angleEntry.GuildId = channel.GuildID
+		angleEntry.ChannelId = channel.ID
+		angleEntry.SubmittedAt = m.Timestamp
+		// Messages come with the current names of their author
+		refreshUser(channel.GuildID, m.Author, nil)
+
+		stored, reason, err := SubmitAngleEntry(angleEntry)
+		if err != nil {
+			return report, err
+		}
+		if !stored {
+			log.Printf("Skipped %s entry from %s in message %s: %s", game.Name(), m.Author.ID, m.ID, reason)
+			report.Skipped++
+			continue
+		}
+		report.Imported++
+	}
+
+	log.Printf("Backfill of channel %s: %s", channelId, report)
+	return report, nil
+}
+
+func GetBackfillMessage(s *discordgo.Session, m *discordgo.MessageCreate) string {
+	if !IsAdmin(s, m.Message) {
+		return "Only admins can backfill results"
+	}
+
+	channelId := m.ChannelID
+	for _, arg := range strings.Fields(m.Content)[1:] {
+		if strings.HasPrefix(arg, "<#") {
+			channelId = parseChannelMention(arg)
+		}
+	}
+	channel, err := s.Channel(channelId)
+	if err != nil || channel.GuildID != m.GuildID {
+		return "Only channels of this server can be backfilled"
+	}
+
+	report, err := BackfillChannel(s, channelId)
+	if err != nil {
+		log.Println(err)
+		return fmt.Sprintf("Backfill stopped early: %s", report)
+	}
+	return report.String()
+}
+
+// parseChannelMention returns the id of a <#id> channel mention, anything else
+// is returned as is.
+func parseChannelMention(arg string) string {
+	if strings.HasPrefix(arg, "<#") && strings.HasSuffix(arg, ">") {
+		return arg[2 : len(arg)-1]
+	}
+	return arg
+}
+
+func IsAdmin(s *discordgo.Session, m *discordgo.Message) bool {
+	permissions, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err != nil {
+		log.Printf("Error getting permissions of %s: %v", m.Author.ID, err)
+		return false
+	}
+	return permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Snapshots are named after the time they were taken, in UTC, down to the
+// nanosecond so two backups made in the same second don't collide. Names are
+// read without the fraction of a second, it's parsed when there is one so
+// older names are read too.
+const (
+	backupNameFormat = "angler-20060102-150405.000000000.db"
+	backupNameLayout = "angler-20060102-150405.db"
+)
+
+// BackupStore is implemented by the backends that can write a snapshot of
+// their database to a file.
+type BackupStore interface {
+	BackupTo(path string) error
+}
+
+var ErrBackupsNotSupported = errors.New("backups are only supported with SQLite")
+
+type Backup struct {
+	Name string
+	Time time.Time
+}
+
+// CreateBackup writes a snapshot of the database to the backup directory and
+// removes the snapshots the retention rules don't keep.
+func CreateBackup() (string, error) {
+	path, err := writeBackup()
+	if err != nil {
+		return "", err
+	}
+
+	err = pruneBackups(*BackupDir, *BackupKeepDaily, *BackupKeepMonthly)
+	return path, err
+}
+
+func writeBackup() (string, error) {
+	backupStore, ok := store.(BackupStore)
+	if !ok {
+		return "", ErrBackupsNotSupported
+	}
+
+	err := os.MkdirAll(*BackupDir, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("Error creating backup directory: %w", err)
+	}
+
+	path := filepath.Join(*BackupDir, time.Now().UTC().Format(backupNameFormat))
+	err = backupStore.BackupTo(path)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Saved backup %s", path)
+	return path, nil
+}
+
+// listBackups returns the snapshots in a directory, newest first.
+func listBackups(dir string) ([]Backup, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, file := range files {
+		backupTime, err := time.Parse(backupNameLayout, file.Name())
+		if err != nil || file.IsDir() {
+			continue
+		}
+		backups = append(backups, Backup{Name: file.Name(), Time: backupTime})
+	}
+	slices.SortFunc(backups, func(a Backup, b Backup) int {
+		return b.Time.Compare(a.Time)
+	})
+	return backups, nil
+}
+
+// pruneBackups keeps the newest snapshot of each of the last keepDaily days
+// and of each of the last keepMonthly months that have one, the newest
+// snapshot is always kept.
+func pruneBackups(dir string, keepDaily int, keepMonthly int) error {
+	backups, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+
+	days := map[string]bool{}
+	months := map[string]bool{}
+	for i, backup := range backups {
+		day := backup.Time.Format(time.DateOnly)
+		month := backup.Time.Format("2006-01")
+		keep := i == 0
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep = true
+		}
+		if !months[month] && len(months) < keepMonthly {
+			months[month] = true
+			keep = true
+		}
+		if keep {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(dir, backup.Name))
+		if err != nil {
+			return fmt.Errorf("Error removing backup %s: %w", backup.Name, err)
+		}
+		log.Printf("Removed backup %s", backup.Name)
+	}
+	return nil
+}
+
+func GetBackupMessage(s *discordgo.Session, m *discordgo.MessageCreate) string {
+	if !IsAdmin(s, m.Message) {
+		return "Only admins can make backups"
+	}
+
+	path, err := CreateBackup()
+	if errors.Is(err, ErrBackupsNotSupported) {
+		return "Backups are only supported with SQLite"
+	} else if err != nil {
+		log.Println(err)
+		if path != "" {
+			return fmt.Sprintf("Saved backup %s but couldn't remove old ones", filepath.Base(path))
+		}
+		return "Couldn't make the backup, try again"
+	}
+	return fmt.Sprintf("Saved backup %s", filepath.Base(path))
+}
+
+// validateSnapshot checks that a snapshot is a healthy database of the bot
+// with a schema this version knows about.
+func validateSnapshot(path string) error {
+	_, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	integrity := ""
+	err = db.QueryRow("PRAGMA integrity_check").Scan(&integrity)
+	if err != nil {
+		return fmt.Errorf("Error checking snapshot %s: %w", path, err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("Snapshot %s is corrupted: %s", path, integrity)
+	}
+
+	var version sql.NullInt64
+	err = db.QueryRow("select max(version) from schema_version").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("Snapshot %s is not a database of the bot: %w", path, err)
+	}
+	migrations, err := loadMigrations(sqliteDialect)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].Version; int(version.Int64) > latest {
+		return fmt.Errorf("Snapshot %s is at schema version %d, newer than %d", path, version.Int64, latest)
+	}
+
+	var count int
+	err = db.QueryRow("select count(*) from angle_tries").Scan(&count)
+	if err != nil {
+		return fmt.Errorf("Snapshot %s is not a database of the bot: %w", path, err)
+	}
+	log.Printf("Snapshot %s is valid, it has %d entries", path, count)
+	return nil
+}
+
+// runRestoreCommand replaces the database with a snapshot, the current
+// database is backed up first and no backups are removed. The bot must not be
+// running.
+func runRestoreCommand(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: restore <snapshot>")
+	}
+	snapshot := args[0]
+	if _, ok := store.(BackupStore); !ok {
+		return ErrBackupsNotSupported
+	}
+
+	dbPath := sqlitePath(*DatabaseDsn)
+	restoring := dbPath + ".restoring"
+	err := copyFile(snapshot, restoring)
+	if err != nil {
+		return fmt.Errorf("Error copying snapshot: %w", err)
+	}
+	err = validateSnapshot(restoring)
+	if err != nil {
+		os.Remove(restoring)
+		return err
+	}
+
+	current, err := writeBackup()
+	if err != nil {
+		return fmt.Errorf("Error backing up the current database: %w", err)
+	}
+	fmt.Printf("Backed up the current database to %s\n", current)
+
+	err = store.Close()
+	if err != nil {
+		return err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		err = os.Remove(dbPath + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	err = os.Rename(restoring, dbPath)
+	if err != nil {
+		return fmt.Errorf("Error swapping in snapshot: %w", err)
+	}
+	fmt.Printf("Restored %s from %s\n", dbPath, snapshot)
+	return nil
+}
+
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	file, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, source)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sqlitePath returns the file of a SQLite DSN.
+func sqlitePath(dsn string) string {
+	path, _, _ := strings.Cut(dsn, "?")
+	return strings.TrimPrefix(path, "file:")
+}
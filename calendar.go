@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Angle issue released on July 1 2025, the first day of the first season
+const firstSeasonAngleIssue = 1106
+
+var firstSeasonStart = time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+
+// Calendar says which angle issue is out at any time, a new issue comes out
+// every day at Rollover after midnight in Location. Issues and seasons are
+// placed in days of this calendar, days are written as midnight UTC dates.
+type Calendar struct {
+	Location *time.Location
+	Rollover time.Duration
+}
+
+// Angle comes out at 7am GMT-5
+var calendar = Calendar{Location: time.FixedZone("-05:00", -5*60*60), Rollover: 7 * time.Hour}
+
+var utcOffset = regexp.MustCompile(`^(?:UTC|GMT)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// NewCalendar reads the timezone as an IANA name like America/Bogota or an
+// offset like -05:00, and the rollover as the time of the day like 07:00.
+func NewCalendar(timezone string, rollover string) (Calendar, error) {
+	location, err := parseTimezone(timezone)
+	if err != nil {
+		return Calendar{}, err
+	}
+
+	rolloverTime, err := time.Parse("15:04", rollover)
+	if err != nil {
+		return Calendar{}, fmt.Errorf("Invalid rollover %q, it should look like 07:00", rollover)
+	}
+	return Calendar{Location: location, Rollover: time.Duration(rolloverTime.Hour())*time.Hour + time.Duration(rolloverTime.Minute())*time.Minute}, nil
+}
+
+// parseTimezone reads offsets as fixed zones, they don't observe DST.
+func parseTimezone(timezone string) (*time.Location, error) {
+	match := utcOffset.FindStringSubmatch(timezone)
+	if match == nil {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timezone %q: %w", timezone, err)
+		}
+		return location, nil
+	}
+
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	if hours > 14 || minutes > 59 {
+		return nil, fmt.Errorf("Invalid timezone %q, offsets go from -14:00 to +14:00", timezone)
+	}
+	offset := hours*60*60 + minutes*60
+	if match[1] == "-" {
+		offset = -offset
+	}
+	return time.FixedZone(fmt.Sprintf("%s%02d:%02d", match[1], hours, minutes), offset), nil
+}
+
+// Day returns the day of the issue that is out at t. The rollover is checked
+// against the local clock so days stay right when it changes for DST.
+func (c Calendar) Day(t time.Time) time.Time {
+	local := t.In(c.Location)
+	year, month, day := local.Date()
+	clock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+	if clock < c.Rollover {
+		day--
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// IssueAt returns the angle issue that is out at t.
+func (c Calendar) IssueAt(t time.Time) int {
+	return c.DailyIssueAt(firstSeasonAngleIssue, firstSeasonStart, t)
+}
+
+// DailyIssueAt returns the issue of a daily game that is out at t, the game
+// released firstIssue on firstDay.
+func (c Calendar) DailyIssueAt(firstIssue int, firstDay time.Time, t time.Time) int {
+	return dailyIssueForDate(firstIssue, firstDay, c.Day(t))
+}
+
+// AtMidnight is the calendar of games that come out at midnight in the same
+// timezone.
+func (c Calendar) AtMidnight() Calendar {
+	return Calendar{Location: c.Location}
+}
+
+// dailyIssueForDate returns the issue a daily game released on a day.
+func dailyIssueForDate(firstIssue int, firstDay time.Time, date time.Time) int {
+	year, month, day := date.Date()
+	days := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Sub(firstDay) / (24 * time.Hour)
+	return firstIssue + int(days)
+}
+
+// dailyIssueDate returns the day a daily game released an issue.
+func dailyIssueDate(firstIssue int, firstDay time.Time, issue int) time.Time {
+	return firstDay.AddDate(0, 0, issue-firstIssue)
+}
+
+// angleIssueForDate returns the angle issue of a day.
+func angleIssueForDate(date time.Time) int {
+	return dailyIssueForDate(firstSeasonAngleIssue, firstSeasonStart, date)
+}
+
+// GetAngleIssueDate returns the day of an angle issue.
+func GetAngleIssueDate(angleIssue int) time.Time {
+	return dailyIssueDate(firstSeasonAngleIssue, firstSeasonStart, angleIssue)
+}
+
+func GetTodayAngleIssue() int {
+	return calendar.IssueAt(time.Now())
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustCalendar(t *testing.T, timezone string, rollover string) Calendar {
+	c, err := NewCalendar(timezone, rollover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCalendarIssueAt(t *testing.T) {
+	angle := mustCalendar(t, "-05:00", "07:00")
+	kiribati := mustCalendar(t, "+14:00", "07:00")
+	india := mustCalendar(t, "+05:30", "00:00")
+	newYork := mustCalendar(t, "America/New_York", "07:00")
+	bogota := mustCalendar(t, "America/Bogota", "07:00")
+
+	tests := []struct {
+		name     string
+		calendar Calendar
+		at       string
+		issue    int
+	}{
+		{"before the rollover", angle, "2025-07-01T11:59:59Z", 1105},
+		{"at the rollover", angle, "2025-07-01T12:00:00Z", 1106},
+		{"after midnight UTC", angle, "2025-07-02T03:00:00Z", 1106},
+		{"last issue of the month", angle, "2025-08-01T11:59:59Z", 1136},
+		{"first issue of the month", angle, "2025-08-01T12:00:00Z", 1137},
+		{"last issue of the year", angle, "2026-01-01T11:59:59Z", 1289},
+		{"first issue of the year", angle, "2026-01-01T12:00:00Z", 1290},
+		{"far ahead offset before the rollover", kiribati, "2025-06-30T16:59:59Z", 1105},
+		{"far ahead offset at the rollover", kiribati, "2025-06-30T17:00:00Z", 1106},
+		{"half hour offset before midnight", india, "2025-07-31T18:29:59Z", 1136},
+		{"half hour offset at midnight", india, "2025-07-31T18:30:00Z", 1137},
+		{"zone without DST", bogota, "2026-01-01T12:00:00Z", 1290},
+		{"summer time before the rollover", newYork, "2025-08-01T10:59:59Z", 1136},
+		{"summer time at the rollover", newYork, "2025-08-01T11:00:00Z", 1137},
+		{"winter time before the rollover", newYork, "2026-01-01T11:59:59Z", 1289},
+		{"winter time at the rollover", newYork, "2026-01-01T12:00:00Z", 1290},
+		{"day clocks go forward", newYork, "2026-03-08T11:00:00Z", 1356},
+		{"day clocks go back", newYork, "2026-11-01T11:59:59Z", 1593},
+		{"day clocks go back at the rollover", newYork, "2026-11-01T12:00:00Z", 1594},
+	}
+
+	for _, test := range tests {
+		at, err := time.Parse(time.RFC3339, test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if issue := test.calendar.IssueAt(at); issue != test.issue {
+			t.Errorf("%s: issue at %s is %d, want %d", test.name, test.at, issue, test.issue)
+		}
+	}
+}
+
+func TestCalendarDailyGames(t *testing.T) {
+	angle := mustCalendar(t, "-05:00", "07:00")
+	wordleStart := time.Date(2021, time.June, 19, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		at    string
+		issue int
+	}{
+		{"2025-07-01T04:59:59Z", 1472},
+		{"2025-07-01T05:00:00Z", 1473},
+		// Still yesterday's angle issue but already today's Wordle
+		{"2025-07-01T11:00:00Z", 1473},
+	}
+	for _, test := range tests {
+		at, err := time.Parse(time.RFC3339, test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if issue := angle.AtMidnight().DailyIssueAt(0, wordleStart, at); issue != test.issue {
+			t.Errorf("Wordle issue at %s is %d, want %d", test.at, issue, test.issue)
+		}
+	}
+
+	if day := dailyIssueDate(0, wordleStart, 1473); !day.Equal(firstSeasonStart) {
+		t.Errorf("Wordle #1473 came out on %v, want %v", day, firstSeasonStart)
+	}
+	if issue := toAngleIssue(WordleGame, 1473); issue != firstSeasonAngleIssue {
+		t.Errorf("Wordle #1473 is angle issue %d, want %d", issue, firstSeasonAngleIssue)
+	}
+}
+
+func TestNewCalendar(t *testing.T) {
+	tests := []struct {
+		timezone string
+		rollover string
+		offset   int
+		valid    bool
+	}{
+		{"-05:00", "07:00", -5 * 60 * 60, true},
+		{"+14:00", "07:00", 14 * 60 * 60, true},
+		{"UTC-0530", "07:00", -(5*60 + 30) * 60, true},
+		{"GMT+3", "07:00", 3 * 60 * 60, true},
+		{"UTC", "00:00", 0, true},
+		{"+15:00", "07:00", 0, false},
+		{"-05:60", "07:00", 0, false},
+		{"Mars/Olympus_Mons", "07:00", 0, false},
+		{"-05:00", "7am", 0, false},
+		{"-05:00", "25:00", 0, false},
+	}
+
+	for _, test := range tests {
+		c, err := NewCalendar(test.timezone, test.rollover)
+		if (err == nil) != test.valid {
+			t.Errorf("NewCalendar(%q, %q) returned %v, want valid %v", test.timezone, test.rollover, err, test.valid)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if _, offset := time.Date(2025, time.July, 1, 0, 0, 0, 0, c.Location).Zone(); offset != test.offset {
+			t.Errorf("NewCalendar(%q, %q) has offset %d, want %d", test.timezone, test.rollover, offset, test.offset)
+		}
+	}
+}
+
+func TestSeasonForIssue(t *testing.T) {
+	monthly := generateSeasons(nil, MonthlyCadence, 1300)
+	custom := []Season{{Number: 1, StartIssue: 1106, EndIssue: 1120}, {Number: 2, StartIssue: 1130, EndIssue: 1140}}
+
+	tests := []struct {
+		name    string
+		seasons []Season
+		game    string
+		issue   int
+		season  int
+	}{
+		{"before the first season", monthly, AngleGame, 1000, 1},
+		{"first issue of a season", monthly, AngleGame, 1106, 1},
+		{"last issue of a season", monthly, AngleGame, 1136, 1},
+		{"first issue of the next season", monthly, AngleGame, 1137, 2},
+		{"last issue of the year", monthly, AngleGame, 1289, 6},
+		{"first issue of the year", monthly, AngleGame, 1290, 7},
+		{"other game on the last day of a season", monthly, WordleGame, 1503, 1},
+		{"other game on the first day of a season", monthly, WordleGame, 1504, 2},
+		{"between custom seasons", custom, AngleGame, 1125, 1},
+		{"first issue of a custom season", custom, AngleGame, 1130, 2},
+		{"after the last custom season", custom, AngleGame, 1200, 2},
+		{"no seasons", nil, AngleGame, 1200, 0},
+	}
+
+	for _, test := range tests {
+		if season := seasonForIssue(test.seasons, test.game, test.issue); season != test.season {
+			t.Errorf("%s: %s #%d is in season %d, want %d", test.name, test.game, test.issue, season, test.season)
+		}
+	}
+}
@@ -2,26 +2,46 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-var tableStatements = map[string]string{
-	"angle_tries": "CREATE table angle_tries(id text not null primary key, user_id text, global_name text, angle_issue integer, tries integer, off_by integer, completed integer);",
-	"fail_quotes": "CREATE table fail_quotes(id text not null primary key, guild_id text, quote text);"}
+const (
+	GuessUp   = "up"
+	GuessDown = "down"
+	GuessHit  = "hit"
+)
 
 type AngleEntry struct {
-	UserId     string
-	GlobalName string
-	AngleIssue int
-	Tries      int
-	OffBy      int
-	Completed  int
+	Id        string
+	MessageId string
+	GuildId   string
+	ChannelId string
+	// SubmittedAt is when the message with the entry was posted, it's zero
+	// for entries stored before it was tracked
+	SubmittedAt time.Time
+	Game        string
+	UserId      string
+	GlobalName  string
+	AngleIssue  int
+	Tries       int
+	OffBy       int
+	Completed   int
+	Guesses     []AngleGuess
+}
+
+type AngleGuess struct {
+	Number    int
+	Direction string
+	// OffBy is -1 when the share text doesn't say how far off the guess was
+	OffBy int
 }
 
 type QuoteEntry struct {
@@ -30,156 +50,697 @@ type QuoteEntry struct {
 	GuildId string
 }
 
+// UserEntry holds the names a user goes by, Nickname is the one in GuildId.
+type UserEntry struct {
+	Id         string
+	Username   string
+	GlobalName string
+	GuildId    string
+	Nickname   string
+}
+
 type Score struct {
 	User   string
 	Score  int
 	Played int
 	Wins   int
+	// Tries adds up the tries of every result, completed or not
+	Tries int
+	// Missed counts the issues a registered participant didn't play, only
+	// when the scoring rules score them
+	Missed int
 	Id     string
 }
 
-func checkTableExist(tableName string, db *sql.DB) bool {
-	stmt, err := db.Prepare("SELECT name FROM sqlite_master WHERE type='table' AND name=?;")
+// PointsPerGame is the average score of the issues played or missed.
+func (score Score) PointsPerGame() float64 {
+	games := score.Played + score.Missed
+	if games == 0 {
+		return 0
+	}
+	return float64(score.Score) / float64(games)
+}
+
+// compareScoresPerGame orders standings by average score, ties go through
+// the same tie-breaks as the total score.
+func compareScoresPerGame(a Score, b Score) int {
+	// a.Score/aGames against b.Score/bGames without dividing
+	if perGame := b.Score*(a.Played+a.Missed) - a.Score*(b.Played+b.Missed); perGame != 0 {
+		return perGame
+	}
+	return compareScores(a, b)
+}
+
+// compareScores orders the standings: the higher score goes first, then the
+// most wins, then the fewest tries on average and then the fewest plays. It
+// returns 0 when the players are tied on all of them.
+func compareScores(a Score, b Score) int {
+	if a.Score != b.Score {
+		return b.Score - a.Score
+	}
+	if a.Wins != b.Wins {
+		return b.Wins - a.Wins
+	}
+	// a.Tries/a.Played against b.Tries/b.Played without dividing
+	if averageTries := a.Tries*b.Played - b.Tries*a.Played; averageTries != 0 {
+		return averageTries
+	}
+	return a.Played - b.Played
+}
+
+// rankScores returns the rank of each score of standings sorted by compare,
+// tied players share the rank of the first of them.
+func rankScores(scoreStandings []Score, compare func(a Score, b Score) int) []int {
+	ranks := make([]int, len(scoreStandings))
+	for pos, score := range scoreStandings {
+		ranks[pos] = pos + 1
+		if pos > 0 && compare(scoreStandings[pos-1], score) == 0 {
+			ranks[pos] = ranks[pos-1]
+		}
+	}
+	return ranks
+}
+
+func (st *Store) InsertAngleTryEntry(angleEntry AngleEntry) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seasons, err := saveGeneratedSeasons(tx, angleEntry.GuildId)
+	if err != nil {
+		return err
+	}
+
+	err = insertAngleTryEntry(tx, angleEntry, seasonForIssue(seasons, angleEntry.Game, angleEntry.AngleIssue))
+	if err != nil {
+		return err
+	}
+
+	err = replayRatings(tx, angleEntry.GuildId, angleEntry.Game, angleEntry.AngleIssue)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertAngleTryEntry(tx *sqlTx, angleEntry AngleEntry, season int) error {
+	newId := uuid.NewString()
+	_, err := tx.Exec("insert into angle_tries(id, user_id, global_name, angle_issue, tries, off_by, completed, season, game, message_id, guild_id, channel_id, submitted_at) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", newId, angleEntry.UserId, angleEntry.GlobalName, angleEntry.AngleIssue, angleEntry.Tries, angleEntry.OffBy, angleEntry.Completed, season, angleEntry.Game, angleEntry.MessageId, angleEntry.GuildId, angleEntry.ChannelId, unixTimeOrNull(angleEntry.SubmittedAt))
+	if err != nil {
+		return fmt.Errorf("Error inserting %s entry from %s: %w", angleEntry.Game, angleEntry.UserId, err)
+	}
+
+	err = insertAngleGuesses(tx, newId, angleEntry.Guesses)
+	if err != nil {
+		return fmt.Errorf("Error inserting guesses: %w", err)
+	}
+
+	return insertAuditLog(tx, AuditEntry{GuildId: angleEntry.GuildId, ActorId: angleEntry.UserId, Action: AuditEntryInsert, TargetId: newId, After: auditAngleEntryValue(angleEntry)})
+}
+
+// SubmitAngleTryEntry stores an entry unless the user already has one for the
+// issue, with the keep_best policy a worse stored entry is replaced. The
+// lookup, the replacement and the insert happen in one transaction. It returns
+// the entry that was kept instead when the new one isn't stored.
+func (st *Store) SubmitAngleTryEntry(angleEntry AngleEntry, policy string) (AngleEntry, bool, error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return AngleEntry{}, false, err
+	}
+	defer tx.Rollback()
+
+	existingEntry, err := scanAngleEntry(tx.QueryRow("select "+angleEntryColumns+" from angle_tries where coalesce(guild_id, '') = ? and user_id = ? and game = ? and angle_issue = ? and deleted_at is null", angleEntry.GuildId, angleEntry.UserId, angleEntry.Game, angleEntry.AngleIssue))
+	if err == nil {
+		if policy != KeepBestPolicy || !isBetterEntry(angleEntry, existingEntry) {
+			return existingEntry, false, nil
+		}
+		_, _, err = retractAngleTryEntry(tx, existingEntry.Id, angleEntry.UserId)
+		if err != nil {
+			return AngleEntry{}, false, err
+		}
+		log.Printf("Replaced %s entry %s for issue %d from %s with a better result", existingEntry.Game, existingEntry.Id, existingEntry.AngleIssue, existingEntry.UserId)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return AngleEntry{}, false, err
+	}
+
+	seasons, err := saveGeneratedSeasons(tx, angleEntry.GuildId)
+	if err != nil {
+		return AngleEntry{}, false, err
+	}
+	err = insertAngleTryEntry(tx, angleEntry, seasonForIssue(seasons, angleEntry.Game, angleEntry.AngleIssue))
+	if err != nil {
+		return AngleEntry{}, false, err
+	}
+
+	err = replayRatings(tx, angleEntry.GuildId, angleEntry.Game, angleEntry.AngleIssue)
+	if err != nil {
+		return AngleEntry{}, false, err
+	}
+	return AngleEntry{}, true, tx.Commit()
+}
+
+func insertAngleGuesses(tx *sqlTx, entryId string, guesses []AngleGuess) error {
+	stmt, err := tx.Prepare("insert into angle_guesses(id, entry_id, guess_number, direction, off_by) values(?, ?, ?, ?, ?)")
 	if err != nil {
-		log.Fatal("Error in statment", err)
+		return err
 	}
 	defer stmt.Close()
 
-	tableExists := ""
-	err = stmt.QueryRow(tableName).Scan(&tableExists)
+	for _, guess := range guesses {
+		var offBy any
+		if guess.OffBy >= 0 {
+			offBy = guess.OffBy
+		}
+		_, err = stmt.Exec(uuid.NewString(), entryId, guess.Number, guess.Direction, offBy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAngleEntryByMessageId returns the entry posted in the given Discord
+// message, or sql.ErrNoRows if the message has no entry.
+func (st *Store) GetAngleEntryByMessageId(messageId string) (AngleEntry, error) {
+	return st.getAngleEntry("select "+angleEntryColumns+" from angle_tries where message_id = ? and deleted_at is null", messageId)
+}
+
+// GetAngleEntryForIssue returns the entry a user already has for an issue in
+// a guild, or sql.ErrNoRows if there is none.
+func (st *Store) GetAngleEntryForIssue(guildId string, userId string, game string, angleIssue int) (AngleEntry, error) {
+	return st.getAngleEntry("select "+angleEntryColumns+" from angle_tries where coalesce(guild_id, '') = ? and user_id = ? and game = ? and angle_issue = ? and deleted_at is null", guildId, userId, game, angleIssue)
+}
+
+const angleEntryColumns = "id, coalesce(message_id, ''), coalesce(guild_id, ''), coalesce(channel_id, ''), coalesce(submitted_at, 0), game, user_id, global_name, angle_issue, tries, off_by, completed"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (st *Store) getAngleEntry(query string, args ...any) (AngleEntry, error) {
+	angleEntry, err := scanAngleEntry(st.db.QueryRow(query, args...))
 	if err != nil {
-		log.Println("Error in query", err)
-		return false
+		return AngleEntry{}, err
 	}
-	return tableName == tableExists
+	angleEntry.Guesses, err = st.ListAngleGuesses(angleEntry.Id)
+	return angleEntry, err
+}
+
+func scanAngleEntry(row rowScanner) (AngleEntry, error) {
+	angleEntry := AngleEntry{}
+	var submittedAt int64
+	err := row.Scan(&angleEntry.Id, &angleEntry.MessageId, &angleEntry.GuildId, &angleEntry.ChannelId, &submittedAt, &angleEntry.Game, &angleEntry.UserId, &angleEntry.GlobalName, &angleEntry.AngleIssue, &angleEntry.Tries, &angleEntry.OffBy, &angleEntry.Completed)
+	if err != nil {
+		return AngleEntry{}, err
+	}
+	if submittedAt > 0 {
+		angleEntry.SubmittedAt = time.Unix(submittedAt, 0)
+	}
+	return angleEntry, nil
+}
+
+func unixTimeOrNull(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+// ListAngleEntriesByChannel returns the entries posted in a channel in the
+// order they were posted.
+func (st *Store) ListAngleEntriesByChannel(channelId string) ([]AngleEntry, error) {
+	return st.listAngleEntries("select "+angleEntryColumns+" from angle_tries where channel_id = ? and deleted_at is null order by submitted_at, rowid", channelId)
+}
+
+// ListAngleEntriesSubmittedBetween returns the entries of a guild posted from
+// the start time and before the end time, in the order they were posted.
+func (st *Store) ListAngleEntriesSubmittedBetween(guildId string, start time.Time, end time.Time) ([]AngleEntry, error) {
+	return st.listAngleEntries("select "+angleEntryColumns+" from angle_tries where guild_id = ? and submitted_at >= ? and submitted_at < ? and deleted_at is null order by submitted_at, rowid", guildId, start.Unix(), end.Unix())
 }
 
-func CreateTables() {
-	db, err := sql.Open("sqlite3", "./foo.db")
+func (st *Store) listAngleEntries(query string, args ...any) ([]AngleEntry, error) {
+	rows, err := st.db.Query(query, args...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer db.Close()
+	defer rows.Close()
+
+	entries := []AngleEntry{}
+	for rows.Next() {
+		angleEntry, err := scanAngleEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, angleEntry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	err = st.loadAngleGuesses(entries)
+	return entries, err
+}
+
+// Entries whose guesses are read in one query, kept under the limit of bound
+// parameters of SQLite.
+const guessesBatchSize = 500
+
+// loadAngleGuesses reads the guesses of all the entries at once instead of
+// one query per entry.
+func (st *Store) loadAngleGuesses(entries []AngleEntry) error {
+	for start := 0; start < len(entries); start += guessesBatchSize {
+		batch := entries[start:min(start+guessesBatchSize, len(entries))]
+		entryIds := make([]any, len(batch))
+		for i, angleEntry := range batch {
+			entryIds[i] = angleEntry.Id
+		}
 
-	for tableName, tableStament := range tableStatements {
-		tableExist := checkTableExist(tableName, db)
-		if !tableExist {
-			fmt.Println("Creating table", tableName)
-			_, err = db.Exec(tableStament)
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := st.db.Query("select entry_id, guess_number, direction, coalesce(off_by, -1) from angle_guesses where entry_id in ("+placeholders+") order by entry_id, guess_number", entryIds...)
+		if err != nil {
+			return fmt.Errorf("Error reading guesses: %w", err)
+		}
+		guesses := map[string][]AngleGuess{}
+		for rows.Next() {
+			var entryId string
+			var guess AngleGuess
+			err = rows.Scan(&entryId, &guess.Number, &guess.Direction, &guess.OffBy)
 			if err != nil {
-				log.Printf("%q: %s\n", err, tableStament)
-				return
+				rows.Close()
+				return err
+			}
+			guesses[entryId] = append(guesses[entryId], guess)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for i := range batch {
+			batch[i].Guesses = guesses[batch[i].Id]
+			if batch[i].Guesses == nil {
+				batch[i].Guesses = []AngleGuess{}
 			}
 		}
 	}
+	return nil
 }
 
-func InsertAngleTryEntry(angleEntry AngleEntry) {
-	db, err := sql.Open("sqlite3", "./foo.db")
+// AngleEntryMessageExists checks if a message was ever stored as an entry,
+// including entries that were retracted.
+func (st *Store) AngleEntryMessageExists(messageId string) (bool, error) {
+	var count int
+	err := st.db.QueryRow("select count(*) from angle_tries where message_id = ?", messageId).Scan(&count)
 	if err != nil {
-		log.Fatal(err)
+		return false, fmt.Errorf("Error looking up message %s: %w", messageId, err)
 	}
-	defer db.Close()
+	return count > 0, nil
+}
 
-	tx, err := db.Begin()
+// UpdateAngleTryEntry replaces the result and guesses of the entry with the
+// same id, used when the share message gets edited.
+func (st *Store) UpdateAngleTryEntry(angleEntry AngleEntry) error {
+	tx, err := st.db.Begin()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("insert into angle_tries(id, user_id, global_name, angle_issue, tries, off_by, completed, season) values(?, ?, ?, ?, ?, ?, ?, ?)")
+	seasons, err := saveGeneratedSeasons(tx, angleEntry.GuildId)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	before, err := getAuditAngleEntry(tx, angleEntry.Id)
+	if err != nil {
+		return err
 	}
-	defer stmt.Close()
 
-	currentSeason := GetCurrentSeason()
+	_, err = tx.Exec("update angle_tries set game = ?, global_name = ?, angle_issue = ?, tries = ?, off_by = ?, completed = ?, season = ? where id = ?", angleEntry.Game, angleEntry.GlobalName, angleEntry.AngleIssue, angleEntry.Tries, angleEntry.OffBy, angleEntry.Completed, seasonForIssue(seasons, angleEntry.Game, angleEntry.AngleIssue), angleEntry.Id)
+	if err != nil {
+		return fmt.Errorf("Error while updating entry %s: %w", angleEntry.Id, err)
+	}
 
-	newId := uuid.NewString()
-	_, err = stmt.Exec(newId, angleEntry.UserId, angleEntry.GlobalName, angleEntry.AngleIssue, angleEntry.Tries, angleEntry.OffBy, angleEntry.Completed, currentSeason)
+	_, err = tx.Exec("delete from angle_guesses where entry_id = ?", angleEntry.Id)
 	if err != nil {
-		log.Println("error in stmt exc")
-		log.Fatal(err)
+		return fmt.Errorf("Error while removing guesses of entry %s: %w", angleEntry.Id, err)
 	}
 
-	err = tx.Commit()
+	err = insertAngleGuesses(tx, angleEntry.Id, angleEntry.Guesses)
+	if err != nil {
+		return fmt.Errorf("Error while inserting guesses of entry %s: %w", angleEntry.Id, err)
+	}
+
+	err = insertAuditLog(tx, AuditEntry{GuildId: before.GuildId, ActorId: angleEntry.UserId, Action: AuditEntryUpdate, TargetId: angleEntry.Id, Before: auditAngleEntryValue(before), After: auditAngleEntryValue(angleEntry)})
+	if err != nil {
+		return err
+	}
+
+	// An edit can move the entry to another issue or game
+	err = replayRatings(tx, before.GuildId, before.Game, min(before.AngleIssue, angleEntry.AngleIssue))
 	if err != nil {
-		log.Println("error in stmt commit")
-		log.Fatal(err)
+		return err
+	}
+	if angleEntry.Game != before.Game {
+		err = replayRatings(tx, before.GuildId, angleEntry.Game, angleEntry.AngleIssue)
+		if err != nil {
+			return err
+		}
 	}
+
+	return tx.Commit()
 }
 
-func ShowAngleTriesTable() {
-	db, err := sql.Open("sqlite3", "./foo.db")
-	rows, err := db.Query("select * from angle_tries")
+// RetractAngleTryEntry soft deletes an entry so it no longer counts for
+// standings or stats, actorId is who retracted it if known.
+func (st *Store) RetractAngleTryEntry(entryId string, actorId string) error {
+	tx, err := st.db.Begin()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
+
+	before, retracted, err := retractAngleTryEntry(tx, entryId, actorId)
+	if err != nil || !retracted {
+		return err
+	}
+
+	err = replayRatings(tx, before.GuildId, before.Game, before.AngleIssue)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// retractAngleTryEntry returns the entry as it was before, and false if it
+// was already retracted.
+func retractAngleTryEntry(tx *sqlTx, entryId string, actorId string) (AngleEntry, bool, error) {
+	before, err := getAuditAngleEntry(tx, entryId)
+	if err != nil {
+		return AngleEntry{}, false, err
+	}
+
+	result, err := tx.Exec("update angle_tries set deleted_at = ? where id = ? and deleted_at is null", time.Now().Unix(), entryId)
+	if err != nil {
+		return AngleEntry{}, false, fmt.Errorf("Error while retracting entry %s: %w", entryId, err)
+	}
+	if retracted, err := result.RowsAffected(); err != nil || retracted == 0 {
+		return AngleEntry{}, false, err
+	}
+
+	err = insertAuditLog(tx, AuditEntry{GuildId: before.GuildId, ActorId: actorId, Action: AuditEntryRetract, TargetId: entryId, Before: auditAngleEntryValue(before)})
+	if err != nil {
+		return AngleEntry{}, false, err
+	}
+	return before, true, nil
+}
+
+// RecomputeSeasons sets the season of the entries of a guild from their issue
+// and the seasons of the guild, fixing entries stored with the season of the
+// day they were posted.
+func (st *Store) RecomputeSeasons(guildId string, actorId string) (int, error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
+	seasons, err := saveGeneratedSeasons(tx, guildId)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query("select id, game, angle_issue, coalesce(season, 0) from angle_tries where coalesce(guild_id, '') = ?", guildId)
+	if err != nil {
+		return 0, err
+	}
+
+	type entrySeason struct {
+		Id     string
+		Season int
+	}
+	changed := []entrySeason{}
 	for rows.Next() {
 		var id string
-		var userId string
-		var globalName string
+		var game string
 		var angleIssue int
-		var tries int
-		var offBy int
-		var completed int
 		var season int
+		err = rows.Scan(&id, &game, &angleIssue, &season)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if issueSeason := seasonForIssue(seasons, game, angleIssue); issueSeason != season {
+			changed = append(changed, entrySeason{Id: id, Season: issueSeason})
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
 
-		err = rows.Scan(&id, &userId, &globalName, &angleIssue, &tries, &offBy, &completed, &season)
+	for _, entry := range changed {
+		_, err = tx.Exec("update angle_tries set season = ? where id = ? and coalesce(guild_id, '') = ?", entry.Season, entry.Id, guildId)
 		if err != nil {
-			log.Fatal(err)
+			return 0, fmt.Errorf("Error updating season of entry %s: %w", entry.Id, err)
 		}
-		fmt.Println(id, userId, globalName, angleIssue, tries, offBy, completed, season)
 	}
-	err = rows.Err()
+	if len(changed) > 0 {
+		err = insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: actorId, Action: AuditSeasonRecompute, After: fmt.Sprintf("%d results", len(changed))})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(changed), tx.Commit()
+}
+
+// RecomputeAllSeasons recomputes the seasons of the entries of every guild,
+// for the command line so no one is recorded as making it.
+func (st *Store) RecomputeAllSeasons() (int, error) {
+	rows, err := st.db.Query("select distinct coalesce(guild_id, '') from angle_tries")
+	if err != nil {
+		return 0, err
+	}
+	guildIds := []string{}
+	for rows.Next() {
+		var guildId string
+		err = rows.Scan(&guildId)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		guildIds = append(guildIds, guildId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, guildId := range guildIds {
+		changed, err := st.RecomputeSeasons(guildId, "")
+		total += changed
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Users are shown with their nickname in the guild of the entry, their global
+// display name or their username, whichever they have first.
+const userDisplayName = "coalesce(nullif(m.nickname, ''), nullif(u.global_name, ''), nullif(u.username, ''), a.user_id)"
+
+const userDisplayNameJoin = "left join users u on u.id = a.user_id left join guild_members m on m.guild_id = a.guild_id and m.user_id = a.user_id"
+
+// SaveUser stores the current names of a user and adds them to the names the
+// user went by.
+func (st *Store) SaveUser(user UserEntry) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	_, err = tx.Exec("insert into users(id, username, global_name, updated_at) values(?, ?, ?, ?) on conflict(id) do update set username = excluded.username, global_name = excluded.global_name, updated_at = excluded.updated_at", user.Id, user.Username, user.GlobalName, now)
+	if err != nil {
+		return fmt.Errorf("Error saving user %s: %w", user.Id, err)
+	}
+	err = insertUserName(tx, user.Id, "", user.GlobalName, now)
+	if err != nil {
+		return err
+	}
+
+	if user.GuildId != "" {
+		_, err = tx.Exec("insert into guild_members(guild_id, user_id, nickname, updated_at) values(?, ?, ?, ?) on conflict(guild_id, user_id) do update set nickname = excluded.nickname, updated_at = excluded.updated_at", user.GuildId, user.Id, user.Nickname, now)
+		if err != nil {
+			return fmt.Errorf("Error saving nickname of user %s in guild %s: %w", user.Id, user.GuildId, err)
+		}
+		err = insertUserName(tx, user.Id, user.GuildId, user.Nickname, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func insertUserName(tx *sqlTx, userId string, guildId string, name string, seenAt int64) error {
+	if name == "" {
+		return nil
+	}
+	_, err := tx.Exec("insert into user_names(user_id, guild_id, name, seen_at) values(?, ?, ?, ?) on conflict(user_id, guild_id, name) do nothing", userId, guildId, name, seenAt)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("Error saving name of user %s: %w", userId, err)
 	}
+	return nil
 }
 
-func calculateEntryScore(tries int, completed int) int {
-	if completed == 0 {
-		return 5
+// GetUserDisplayName returns the nickname of a user in a guild, falling back
+// to their global display name and then their username.
+func (st *Store) GetUserDisplayName(guildId string, userId string) (string, error) {
+	name := ""
+	err := st.db.QueryRow("select coalesce(nullif(m.nickname, ''), nullif(u.global_name, ''), nullif(u.username, ''), u.id) from users u left join guild_members m on m.user_id = u.id and m.guild_id = ? where u.id = ?", guildId, userId).Scan(&name)
+	if err != nil {
+		return "", fmt.Errorf("Error reading name of user %s: %w", userId, err)
 	}
+	return name, nil
+}
 
-	if tries == 1 {
-		return 100
-	} else if tries == 2 {
-		return 50
-	} else if tries == 3 {
-		return 30
-	} else {
-		return 15
+// GetGuildSetting returns the value of a guild setting, or defaultValue when
+// the guild never set it.
+func (st *Store) GetGuildSetting(guildId string, key string, defaultValue string) (string, error) {
+	return getGuildSetting(st.db, guildId, key, defaultValue)
+}
+
+func getGuildSetting(q queryer, guildId string, key string, defaultValue string) (string, error) {
+	value := ""
+	err := q.QueryRow("select value from guild_settings where guild_id = ? and key = ?", guildId, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultValue, nil
+	} else if err != nil {
+		return "", fmt.Errorf("Error reading setting %s of guild %s: %w", key, guildId, err)
 	}
+	return value, nil
 }
 
-func getScores(season int, allSeasons bool) []Score {
-	db, err := sql.Open("sqlite3", "./foo.db")
-	var stmt *sql.Stmt
-	var rows *sql.Rows
+func (st *Store) SetGuildSetting(guildId string, key string, value string, actorId string) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	if allSeasons == true {
-		stmt, err = db.Prepare("select * from angle_tries")
+	err = setGuildSetting(tx, guildId, key, value, actorId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setGuildSetting(tx *sqlTx, guildId string, key string, value string, actorId string) error {
+	before := ""
+	err := tx.QueryRow("select value from guild_settings where guild_id = ? and key = ?", guildId, key).Scan(&before)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("Error reading setting %s of guild %s: %w", key, guildId, err)
+	}
+
+	_, err = tx.Exec("insert into guild_settings(guild_id, key, value) values(?, ?, ?) on conflict(guild_id, key) do update set value = excluded.value", guildId, key, value)
+	if err != nil {
+		return fmt.Errorf("Error saving setting %s of guild %s: %w", key, guildId, err)
+	}
+
+	return insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: actorId, Action: AuditSettingChange, TargetId: key, Before: before, After: value})
+}
+
+// ListGuildSettings returns the value of a setting for every guild that set it.
+func (st *Store) ListGuildSettings(key string) (map[string]string, error) {
+	rows, err := st.db.Query("select guild_id, value from guild_settings where key = ?", key)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading setting %s: %w", key, err)
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var guildId string
+		var value string
+		err = rows.Scan(&guildId, &value)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		rows, err = stmt.Query()
-	} else {
-		stmt, err = db.Prepare("select * from angle_tries where season = ?")
+		values[guildId] = value
+	}
+	return values, rows.Err()
+}
+
+// AssignGuild moves the entries and fail quotes stored without a guild to
+// guildId. Entries of a user who already has a result for the same issue in
+// that guild are left out. It's run from the command line so no one is
+// recorded as making it.
+func (st *Store) AssignGuild(guildId string) (int, error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("update angle_tries set guild_id = ? where coalesce(guild_id, '') = '' and (deleted_at is not null or not exists (select 1 from angle_tries b where b.guild_id = ? and b.user_id = angle_tries.user_id and b.game = angle_tries.game and b.angle_issue = angle_tries.angle_issue and b.deleted_at is null))", guildId, guildId)
+	if err != nil {
+		return 0, fmt.Errorf("Error assigning entries to guild %s: %w", guildId, err)
+	}
+	assigned, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err = tx.Exec("update fail_quotes set guild_id = ? where coalesce(guild_id, '') = ''", guildId)
+	if err != nil {
+		return 0, fmt.Errorf("Error assigning fail quotes to guild %s: %w", guildId, err)
+	}
+	failQuotes, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if assigned > 0 || failQuotes > 0 {
+		err = insertAuditLog(tx, AuditEntry{GuildId: guildId, Action: AuditGuildAssign, After: fmt.Sprintf("%d results and %d fail quotes", assigned, failQuotes)})
 		if err != nil {
-			log.Fatal(err)
+			return 0, err
 		}
-		rows, err = stmt.Query(season)
 	}
+	return int(assigned), tx.Commit()
+}
 
-	defer stmt.Close()
+func (st *Store) ListAngleGuesses(entryId string) ([]AngleGuess, error) {
+	rows, err := st.db.Query("select guess_number, direction, coalesce(off_by, -1) from angle_guesses where entry_id = ? order by guess_number", entryId)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
 
-	scores := map[string]Score{}
+	guesses := []AngleGuess{}
+	for rows.Next() {
+		var guess AngleGuess
+		err = rows.Scan(&guess.Number, &guess.Direction, &guess.OffBy)
+		if err != nil {
+			return nil, err
+		}
+		guesses = append(guesses, guess)
+	}
+	return guesses, rows.Err()
+}
+
+func (st *Store) ShowAngleTriesTable() error {
+	rows, err := st.db.Query("select id, user_id, global_name, angle_issue, tries, off_by, completed, season, game from angle_tries")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var id string
@@ -190,57 +751,162 @@ func getScores(season int, allSeasons bool) []Score {
 		var offBy int
 		var completed int
 		var season int
+		var game string
 
-		err = rows.Scan(&id, &userId, &globalName, &angleIssue, &tries, &offBy, &completed, &season)
+		err = rows.Scan(&id, &userId, &globalName, &angleIssue, &tries, &offBy, &completed, &season, &game)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		fmt.Println(id, userId, globalName, angleIssue, tries, offBy, completed, season, game)
+	}
+	return rows.Err()
+}
 
-		entryScore := calculateEntryScore(tries, completed)
+// GetScores adds up the scores of everyone in a guild, each season is scored
+// with the rules it was played under. The entries are counted by result in SQL
+// so only a few rows per user are read.
+func (st *Store) GetScores(guildId string, game string, season int, allSeasons bool) ([]Score, error) {
+	ruleSets, err := st.ListScoringRules(guildId)
+	if err != nil {
+		return nil, err
+	}
 
-		if val, ok := scores[userId]; ok {
-			val.Score += entryScore
-			val.Played += 1
-			if completed == 1 {
-				val.Wins += 1
-			}
-			scores[userId] = val
-		} else {
-			newScore := Score{Id: userId, User: globalName, Score: entryScore, Played: 1, Wins: 0}
-			if completed == 1 {
-				newScore.Wins += 1
-			}
-			scores[userId] = newScore
+	// How close the fails were is scored in the query so results aren't
+	// grouped by it
+	closeness, args := closenessScoreSQL(ruleSets)
+	query := "select a.user_id, " + userDisplayName + ", a.season, a.completed, a.tries, a.closeness, a.entries from (select guild_id, user_id, season, completed, tries, " + closeness + " as closeness, count(*) as entries from angle_tries where guild_id = ? and game = ? and deleted_at is null"
+	args = append(args, guildId, game)
+	if !allSeasons {
+		query += " and season = ?"
+		args = append(args, season)
+	}
+	query += " group by guild_id, user_id, season, completed, tries) a " + userDisplayNameJoin
+
+	rows, err := st.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := map[string]Score{}
+
+	for rows.Next() {
+		var userId string
+		var globalName string
+		var season int
+		var tries int
+		var completed int
+		var closeness int
+		var entries int
+
+		err = rows.Scan(&userId, &globalName, &season, &completed, &tries, &closeness, &entries)
+		if err != nil {
+			return nil, err
 		}
+
+		val, ok := scores[userId]
+		if !ok {
+			val = Score{Id: userId, User: globalName}
+		}
+		val.Score += calculateEntryScore(rulesForSeason(ruleSets, season), tries, completed, 0)*entries + closeness
+		val.Played += entries
+		val.Tries += tries * entries
+		if completed == 1 {
+			val.Wins += entries
+		}
+		scores[userId] = val
 	}
 	err = rows.Err()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	err = st.addMissedIssues(scores, ruleSets, guildId, game, season, allSeasons)
+	if err != nil {
+		return nil, err
 	}
+
 	scoreStandings := []Score{}
 
 	for _, v := range scores {
 		scoreStandings = append(scoreStandings, v)
 	}
 
+	// Tied players are listed by name so the order doesn't change between calls
 	slices.SortFunc(scoreStandings, func(a Score, b Score) int {
-		if a.Score > b.Score {
-			return -1
+		if order := compareScores(a, b); order != 0 {
+			return order
+		}
+		if a.User != b.User {
+			return strings.Compare(a.User, b.User)
 		}
-		return 1
+		return strings.Compare(a.Id, b.Id)
 	})
 
-	return scoreStandings
+	return scoreStandings, nil
+}
+
+// addMissedIssues scores the issues registered participants missed in the
+// seasons whose rules score them.
+func (st *Store) addMissedIssues(scores map[string]Score, ruleSets []ScoringRuleSet, guildId string, game string, season int, allSeasons bool) error {
+	scoresMisses := slices.ContainsFunc(ruleSets, func(ruleSet ScoringRuleSet) bool {
+		_, ok := calculateMissScore(ruleSet.Rules)
+		return ok
+	})
+	if !scoresMisses {
+		return nil
+	}
+
+	missed, err := st.countMissedIssues(guildId, game, season, allSeasons)
+	if err != nil {
+		return err
+	}
+	for userId, seasons := range missed {
+		for missedSeason, count := range seasons {
+			missScore, ok := calculateMissScore(rulesForSeason(ruleSets, missedSeason))
+			if !ok {
+				continue
+			}
+			val, ok := scores[userId]
+			if !ok {
+				val = Score{Id: userId, User: userId}
+				if name, err := st.GetUserDisplayName(guildId, userId); err == nil {
+					val.User = name
+				}
+			}
+			val.Score += missScore * count
+			val.Missed += count
+			scores[userId] = val
+		}
+	}
+	return nil
 }
 
-func GetStandings(season int, allSeasons bool) string {
-	scoreStandings := getScores(season, allSeasons)
+// GetStandings lists the standings by total score, or by average score with
+// perGame so players who don't play every day can be compared.
+func GetStandings(guildId string, game string, season int, allSeasons bool, perGame bool) (string, error) {
+	scoreStandings, err := store.GetScores(guildId, game, season, allSeasons)
+	if err != nil {
+		return "", err
+	}
+	compare := compareScores
+	if perGame {
+		compare = compareScoresPerGame
+		slices.SortStableFunc(scoreStandings, compareScoresPerGame)
+	}
 
 	var seasonText string
 	if allSeasons == true {
 		seasonText = "All seasons\n"
 	} else {
-		seasonText = fmt.Sprintf("Season %d\n", season)
+		seasons, err := store.ListSeasons(guildId)
+		if err != nil {
+			return "", err
+		}
+		seasonText = seasonName(seasons, season) + "\n"
+	}
+	if perGame {
+		seasonText = strings.TrimSuffix(seasonText, "\n") + ", points per game\n"
 	}
 	scoreStr := seasonText
 
@@ -254,63 +920,65 @@ func GetStandings(season int, allSeasons bool) string {
 		longestScore = max(longestScore, scoreLen)
 	}
 
+	ranks := rankScores(scoreStandings, compare)
 	for pos, score := range scoreStandings {
-		percentageWin := 100.0 * float32(score.Wins) / float32(score.Played)
-		scoreStr += fmt.Sprintf("%2d. %*s %*d (%.0f%% win)\n", pos+1, longestUsername, score.User, longestScore, score.Score, percentageWin)
+		rank := strconv.Itoa(ranks[pos])
+		tied := (pos > 0 && ranks[pos-1] == ranks[pos]) || (pos+1 < len(ranks) && ranks[pos+1] == ranks[pos])
+		if tied {
+			rank = "T" + rank
+		}
+		percentageWin := float32(0)
+		if score.Played > 0 {
+			percentageWin = 100.0 * float32(score.Wins) / float32(score.Played)
+		}
+		details := fmt.Sprintf("%.0f%% win", percentageWin)
+		if score.Missed > 0 {
+			details += fmt.Sprintf(", %d missed", score.Missed)
+		}
+		if perGame {
+			scoreStr += fmt.Sprintf("%3s. %*s %6.1f (%d games, %s)\n", rank, longestUsername, score.User, score.PointsPerGame(), score.Played+score.Missed, details)
+		} else {
+			scoreStr += fmt.Sprintf("%3s. %*s %*d (%s)\n", rank, longestUsername, score.User, longestScore, score.Score, details)
+		}
 	}
 
-	return scoreStr
+	return scoreStr, nil
 }
 
-func GetStats(userId string, season int, allSeasons bool) string {
-	db, err := sql.Open("sqlite3", "./foo.db")
-	var stmt *sql.Stmt
+func (st *Store) GetStats(guildId string, userId string, game string, season int, allSeasons bool) (string, error) {
 	var rows *sql.Rows
+	var err error
 
 	if allSeasons == true {
-		stmt, err = db.Prepare("select * from angle_tries where user_id = ? order by angle_issue desc")
-		if err != nil {
-			log.Fatal(err)
-		}
-		rows, err = stmt.Query(userId)
+		rows, err = st.db.Query("select angle_issue, tries, off_by, completed from angle_tries where guild_id = ? and user_id = ? and game = ? and deleted_at is null order by angle_issue desc", guildId, userId, game)
 	} else {
-		stmt, err = db.Prepare("select * from angle_tries where user_id = ? and season = ? order by angle_issue desc")
-		if err != nil {
-			log.Fatal(err)
-		}
-		rows, err = stmt.Query(userId, season)
+		rows, err = st.db.Query("select angle_issue, tries, off_by, completed from angle_tries where guild_id = ? and user_id = ? and game = ? and season = ? and deleted_at is null order by angle_issue desc", guildId, userId, game, season)
 	}
-	defer stmt.Close()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	defer rows.Close()
 
 	type Entry struct {
 		Tries     int
 		Issue     int
+		OffBy     int
 		Completed int
 	}
 
 	entries := []Entry{}
 
 	for rows.Next() {
-		var id string
-		var userId string
-		var globalName string
-		var angleIssue int
-		var tries int
-		var offBy int
-		var completed int
-		var season int
-
-		err = rows.Scan(&id, &userId, &globalName, &angleIssue, &tries, &offBy, &completed, &season)
+		entry := Entry{}
+		err = rows.Scan(&entry.Issue, &entry.Tries, &entry.OffBy, &entry.Completed)
 		if err != nil {
-			log.Fatal(err)
+			return "", err
 		}
-		entry := Entry{Tries: tries, Completed: completed, Issue: angleIssue}
 		entries = append(entries, entry)
 	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
 
 	wins := 0
 	played := len(entries)
@@ -320,7 +988,7 @@ func GetStats(userId string, season int, allSeasons bool) string {
 	streakCount := 0
 
 	if len(entries) == 0 {
-		return "No games played yet!"
+		return "No games played yet!", nil
 	}
 
 	firstEntry := entries[0]
@@ -369,7 +1037,11 @@ func GetStats(userId string, season int, allSeasons bool) string {
 	if allSeasons == true {
 		seasonText = "All seasons\n"
 	} else {
-		seasonText = fmt.Sprintf("Season %d\n", season)
+		seasons, err := st.ListSeasons(guildId)
+		if err != nil {
+			return "", err
+		}
+		seasonText = seasonName(seasons, season) + "\n"
 	}
 
 	stats := seasonText
@@ -379,37 +1051,48 @@ func GetStats(userId string, season int, allSeasons bool) string {
 	stats += fmt.Sprintf("%d Current Streak\n", currentStreak)
 	stats += fmt.Sprintf("%d Max Streak\n", maxStreak)
 
-	return stats
+	// Only games that say how far off a guess was have a miss
+	misses := 0
+	missTotal := 0
+	for _, entry := range entries {
+		if entry.Completed == 0 && entry.OffBy > 0 {
+			misses += 1
+			missTotal += entry.OffBy
+		}
+	}
+	if misses > 0 {
+		stats += fmt.Sprintf("%.1f° Average Miss\n", float32(missTotal)/float32(misses))
+	}
+
+	return stats, nil
 }
 
-func CountOneGuessEntries(userId string, userName string) string {
-	db, err := sql.Open("sqlite3", "./foo.db")
-	stmt, err := db.Prepare("select count(*) from angle_tries where user_id = ? and tries == 1")
+func (st *Store) CountOneGuessEntries(guildId string, userId string) (int, error) {
+	stmt, err := st.db.Prepare("select count(*) from angle_tries where guild_id = ? and user_id = ? and game = 'angle' and tries = 1 and deleted_at is null")
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 	defer stmt.Close()
 
 	var oneGuessEntries int
-	err = stmt.QueryRow(userId).Scan(&oneGuessEntries)
+	err = stmt.QueryRow(guildId, userId).Scan(&oneGuessEntries)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	return fmt.Sprintf("%s ha usado el transportador <:emoji_22:1383877615613509715> %d veces\n", userName, oneGuessEntries)
+	return oneGuessEntries, nil
 }
 
-func GetUsersIds() []string {
-	db, err := sql.Open("sqlite3", "./foo.db")
-	stmt, err := db.Prepare("select distinct user_id from angle_tries")
+func (st *Store) GetUsersIds(guildId string, game string) ([]string, error) {
+	stmt, err := st.db.Prepare("select distinct user_id from angle_tries where guild_id = ? and game = ? and deleted_at is null")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.Query(guildId, game)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -419,24 +1102,23 @@ func GetUsersIds() []string {
 
 		err = rows.Scan(&userId)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		usersIds = append(usersIds, userId)
 	}
-	return usersIds
+	return usersIds, rows.Err()
 }
 
-func GetUserIdsAngleIssueDone(angleIssue int) []string {
-	db, err := sql.Open("sqlite3", "./foo.db")
-	stmt, err := db.Prepare("select user_id from angle_tries where angle_issue = ?")
+func (st *Store) GetUserIdsAngleIssueDone(guildId string, game string, angleIssue int) ([]string, error) {
+	stmt, err := st.db.Prepare("select user_id from angle_tries where guild_id = ? and game = ? and angle_issue = ? and deleted_at is null")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(angleIssue)
+	rows, err := stmt.Query(guildId, game, angleIssue)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -446,74 +1128,81 @@ func GetUserIdsAngleIssueDone(angleIssue int) []string {
 
 		err = rows.Scan(&userId)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		usersIds = append(usersIds, userId)
 	}
-	return usersIds
+	return usersIds, rows.Err()
 }
 
-func InsertFailQuote(guildId string, failQuote string) {
-	db, err := sql.Open("sqlite3", "./foo.db")
+func (st *Store) InsertFailQuote(guildId string, failQuote string, actorId string) error {
+	tx, err := st.db.Begin()
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	tx, err := db.Begin()
-	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer tx.Rollback()
 
 	stmt, err := tx.Prepare("insert into fail_quotes(id, guild_id, quote) values(?, ?, ?)")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer stmt.Close()
 
 	newId := uuid.NewString()
 	_, err = stmt.Exec(newId, guildId, failQuote)
 	if err != nil {
-		log.Println("error in stmt exc")
-		log.Fatal(err)
+		return err
+	}
+
+	err = insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: actorId, Action: AuditQuoteAdd, TargetId: newId, After: failQuote})
+	if err != nil {
+		return err
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println("error in stmt commit")
-		log.Fatal(err)
+		return fmt.Errorf("Error inserting fail quote: %w", err)
 	}
 
 	log.Printf("Inserted %s in guild %s", failQuote, guildId)
+	return nil
 }
 
-func RemoveFailQuote(failQuoteId string, guildId string) error {
-	db, err := sql.Open("sqlite3", "./foo.db")
+func (st *Store) RemoveFailQuote(failQuoteId string, guildId string, actorId string) error {
+	tx, err := st.db.Begin()
 	if err != nil {
-		return fmt.Errorf("Error opening database")
+		return err
 	}
-	defer db.Close()
+	defer tx.Rollback()
 
-	stmt := fmt.Sprintf("DELETE FROM fail_quotes WHERE id = '%s'", failQuoteId)
-	fmt.Println(stmt)
-	_, err = db.Exec(stmt)
+	before := ""
+	err = tx.QueryRow("SELECT quote FROM fail_quotes WHERE id = ? and guild_id = ?", failQuoteId, guildId).Scan(&before)
 	if err != nil {
-		return fmt.Errorf("Error while removing fail quote %s", err)
+		return fmt.Errorf("Error while removing fail quote %w", err)
 	}
-	return nil
+
+	_, err = tx.Exec("DELETE FROM fail_quotes WHERE id = ? and guild_id = ?", failQuoteId, guildId)
+	if err != nil {
+		return fmt.Errorf("Error while removing fail quote %w", err)
+	}
+
+	err = insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: actorId, Action: AuditQuoteRemove, TargetId: failQuoteId, Before: before})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func ListFailQuotes(guildId string) []QuoteEntry {
-	db, err := sql.Open("sqlite3", "./foo.db")
-	stmt, err := db.Prepare("SELECT id, quote FROM fail_quotes WHERE guild_id = ?")
+func (st *Store) ListFailQuotes(guildId string) ([]QuoteEntry, error) {
+	stmt, err := st.db.Prepare("SELECT id, quote FROM fail_quotes WHERE guild_id = ?")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer stmt.Close()
 
 	rows, err := stmt.Query(guildId)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -525,15 +1214,27 @@ func ListFailQuotes(guildId string) []QuoteEntry {
 
 		err = rows.Scan(&id, &quote)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		quoteEntry := QuoteEntry{Id: id, Quote: quote}
 		failQuotes = append(failQuotes, quoteEntry)
 	}
-	return failQuotes
+	return failQuotes, rows.Err()
 }
 
-func GetSeasonWinner(season int) Score {
-	standingScores := getScores(season, false)
-	return standingScores[0]
+// GetSeasonWinners returns the champions of a season, more than one when
+// they are tied after every tie-break and none when nobody played.
+func GetSeasonWinners(guildId string, game string, season int) ([]Score, error) {
+	standingScores, err := store.GetScores(guildId, game, season, false)
+	if err != nil {
+		return nil, err
+	}
+	winners := []Score{}
+	for _, score := range standingScores {
+		if compareScores(standingScores[0], score) != 0 {
+			break
+		}
+		winners = append(winners, score)
+	}
+	return winners, nil
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// seedScores stores about entriesPerUser results for each of users players
+// in one guild, spread over seasons of 30 issues. A third of them are fails
+// with how far off they were.
+func seedScores(tb testing.TB, users int, entriesPerUser int) *SQLiteStore {
+	st, err := OpenSQLiteStore(filepath.Join(tb.TempDir(), "angler.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { st.Close() })
+	if _, err = st.Migrate(); err != nil {
+		tb.Fatal(err)
+	}
+
+	tx, err := st.db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("insert into angle_tries(id, user_id, global_name, angle_issue, tries, off_by, completed, season, game, guild_id) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer stmt.Close()
+
+	random := rand.New(rand.NewSource(1))
+	for user := 0; user < users; user++ {
+		userId := fmt.Sprintf("user%d", user)
+		for issue := 0; issue < entriesPerUser; issue++ {
+			completed, tries, offBy := 1, 1+random.Intn(4), 0
+			if random.Intn(3) == 0 {
+				completed, tries, offBy = 0, 4, 1+random.Intn(179)
+			}
+			_, err = stmt.Exec(fmt.Sprintf("%s-%d", userId, issue), userId, userId, issue, tries, offBy, completed, 1+issue/30, AngleGame, "guild")
+			if err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+
+	rules := DefaultScoringRules
+	rules.ClosePoints = 20
+	if err = st.SaveScoringRules("guild", 1, rules, ""); err != nil {
+		tb.Fatal(err)
+	}
+	rules.CloseRange = 45
+	if err = st.SaveScoringRules("guild", 50, rules, ""); err != nil {
+		tb.Fatal(err)
+	}
+	return st
+}
+
+// TestGetScoresCloseness checks the scores added up in SQL against scoring
+// every entry on its own.
+func TestGetScoresCloseness(t *testing.T) {
+	st := seedScores(t, 5, 3000)
+	ruleSets, err := st.ListScoringRules("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := st.db.Query("select user_id, season, tries, off_by, completed from angle_tries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Score{}
+	for rows.Next() {
+		var userId string
+		var season, tries, offBy, completed int
+		if err = rows.Scan(&userId, &season, &tries, &offBy, &completed); err != nil {
+			t.Fatal(err)
+		}
+		score := want[userId]
+		score.Score += calculateEntryScore(rulesForSeason(ruleSets, season), tries, completed, offBy)
+		score.Played++
+		score.Tries += tries
+		score.Wins += completed
+		want[userId] = score
+	}
+	rows.Close()
+
+	scores, err := st.GetScores("guild", AngleGame, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != len(want) {
+		t.Fatalf("got %d scores, want %d", len(scores), len(want))
+	}
+	for _, score := range scores {
+		expected := want[score.Id]
+		if score.Score != expected.Score || score.Played != expected.Played || score.Tries != expected.Tries || score.Wins != expected.Wins {
+			t.Errorf("score of %s is %+v, want %+v", score.Id, score, expected)
+		}
+	}
+}
+
+func BenchmarkGetScores(b *testing.B) {
+	// 300k entries
+	st := seedScores(b, 60, 5000)
+
+	b.Run("season", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := st.GetScores("guild", AngleGame, 100, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("all seasons", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := st.GetScores("guild", AngleGame, 0, true); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Policies for a user posting more than one result for the same issue
+const (
+	KeepFirstPolicy = "keep_first"
+	KeepBestPolicy  = "keep_best"
+	RejectPolicy    = "reject"
+)
+
+const DuplicatePolicySetting = "duplicates"
+
+var duplicatePolicies = []string{KeepFirstPolicy, KeepBestPolicy, RejectPolicy}
+
+func GetDuplicatePolicy(guildId string) string {
+	policy, err := store.GetGuildSetting(guildId, DuplicatePolicySetting, KeepFirstPolicy)
+	if err != nil {
+		log.Println(err)
+		return KeepFirstPolicy
+	}
+	return policy
+}
+
+// isBetterEntry reports if a is a better result than b, a completed entry
+// beats a failed one, then fewer tries and then being closer wins.
+func isBetterEntry(a AngleEntry, b AngleEntry) bool {
+	if a.Completed != b.Completed {
+		return a.Completed > b.Completed
+	}
+	if a.Tries != b.Tries {
+		return a.Tries < b.Tries
+	}
+	return a.OffBy < b.OffBy
+}
+
+// SubmitAngleEntry stores an entry following the duplicate policy of its
+// guild. When the entry isn't stored it returns false and the reason to give
+// to the user.
+func SubmitAngleEntry(angleEntry AngleEntry) (bool, string, error) {
+	policy := GetDuplicatePolicy(angleEntry.GuildId)
+	_, stored, err := store.SubmitAngleTryEntry(angleEntry, policy)
+	// Another result for the issue got stored at the same time
+	if isUniqueViolation(err) {
+		policy = RejectPolicy
+	} else if err != nil || stored {
+		return stored, "", err
+	}
+
+	return false, duplicateEntryMessage(policy, angleEntry), nil
+}
+
+// duplicateEntryMessage tells the user why angleEntry wasn't stored when they
+// already have a result for its issue.
+func duplicateEntryMessage(policy string, angleEntry AngleEntry) string {
+	switch policy {
+	case KeepBestPolicy:
+		return fmt.Sprintf("You already have an equal or better result for %s #%d, keeping that one.", angleEntry.Game, angleEntry.AngleIssue)
+	case RejectPolicy:
+		return fmt.Sprintf("You already posted a result for %s #%d, only one result per day is allowed.", angleEntry.Game, angleEntry.AngleIssue)
+	}
+	return fmt.Sprintf("You already posted a result for %s #%d, only the first one counts.", angleEntry.Game, angleEntry.AngleIssue)
+}
+
+// isUniqueViolation reports if err comes from breaking a unique index, like
+// the one allowing a single entry per user and issue.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var postgresErr *pq.Error
+	if errors.As(err, &postgresErr) {
+		return postgresErr.Code == "23505"
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AngleGame       = "angle"
+	WordleGame      = "wordle"
+	ConnectionsGame = "connections"
+)
+
+// GameParser recognizes the share text of a daily game and turns it into an
+// entry that can be stored and scored like any other.
+type GameParser interface {
+	Name() string
+	// Example is how the first line of the share text looks like
+	Example() string
+	MaxTries() int
+	TodayIssue() int
+	// IssueDate is the day the issue came out
+	IssueDate(issue int) time.Time
+	Detect(message string) bool
+	Parse(message string, authorId string, authorName string) (AngleEntry, error)
+}
+
+var gameParsers = []GameParser{AngleParser{}, WordleParser{}, ConnectionsParser{}}
+
+func GetGameParser(name string) (GameParser, bool) {
+	for _, gameParser := range gameParsers {
+		if strings.EqualFold(gameParser.Name(), name) {
+			return gameParser, true
+		}
+	}
+	return nil, false
+}
+
+func DetectGame(message string) (GameParser, bool) {
+	for _, gameParser := range gameParsers {
+		if gameParser.Detect(message) {
+			return gameParser, true
+		}
+	}
+	return nil, false
+}
+
+// issueSince returns today's issue of a game that comes out at midnight in
+// the timezone of the calendar, its first issue came out on the given day.
+func issueSince(firstIssue int, year int, month time.Month, day int) int {
+	return calendar.AtMidnight().DailyIssueAt(firstIssue, time.Date(year, month, day, 0, 0, 0, 0, time.UTC), time.Now())
+}
+
+func issueDate(firstIssue int, year int, month time.Month, day int, issue int) time.Time {
+	return dailyIssueDate(firstIssue, time.Date(year, month, day, 0, 0, 0, 0, time.UTC), issue)
+}
+
+type AngleParser struct{}
+
+func (AngleParser) Name() string    { return AngleGame }
+func (AngleParser) Example() string { return "#Angle #1234 2/4" }
+func (AngleParser) MaxTries() int   { return MaxAngleTries }
+func (AngleParser) TodayIssue() int { return GetTodayAngleIssue() }
+
+func (AngleParser) IssueDate(issue int) time.Time { return GetAngleIssueDate(issue) }
+
+func (AngleParser) Detect(message string) bool {
+	return strings.HasPrefix(message, "#Angle")
+}
+
+func (AngleParser) Parse(message string, authorId string, authorName string) (AngleEntry, error) {
+	return ParseAngleEntry(message, authorId, authorName)
+}
+
+type WordleParser struct{}
+
+func (WordleParser) Name() string    { return WordleGame }
+func (WordleParser) Example() string { return "Wordle 1,234 4/6" }
+func (WordleParser) MaxTries() int   { return 6 }
+func (WordleParser) TodayIssue() int { return issueSince(0, 2021, time.June, 19) }
+
+func (WordleParser) IssueDate(issue int) time.Time { return issueDate(0, 2021, time.June, 19, issue) }
+
+// wordleHeader is the start of a Wordle share text, tries out of range are
+// detected so they can be reported
+var wordleHeader = regexp.MustCompile(`^Wordle [\d,]+ [X\d]+/6\*?`)
+
+func (WordleParser) Detect(message string) bool {
+	return wordleHeader.MatchString(message)
+}
+
+func (p WordleParser) Parse(message string, authorId string, authorName string) (AngleEntry, error) {
+	firstLine, _, _ := strings.Cut(message, "\n")
+	firstLineValues := strings.Fields(firstLine)
+	if len(firstLineValues) < 3 {
+		return AngleEntry{}, fmt.Errorf("%w: %q", ErrMalformedHeader, firstLine)
+	}
+
+	issue, err := strconv.Atoi(strings.ReplaceAll(firstLineValues[1], ",", ""))
+	if err != nil {
+		return AngleEntry{}, fmt.Errorf("%w: invalid issue %q", ErrMalformedHeader, firstLineValues[1])
+	}
+	if err := validateIssue(issue, p.TodayIssue()); err != nil {
+		return AngleEntry{}, err
+	}
+
+	// Hard mode results end with an asterisk
+	triesStr, _, found := strings.Cut(strings.TrimSuffix(firstLineValues[2], "*"), "/")
+	if !found {
+		return AngleEntry{}, fmt.Errorf("%w: invalid tries %q", ErrMalformedHeader, firstLineValues[2])
+	}
+	completed := 1
+	tries := p.MaxTries()
+	if triesStr == "X" {
+		completed = 0
+	} else {
+		tries, err = strconv.Atoi(triesStr)
+		if err != nil {
+			return AngleEntry{}, fmt.Errorf("%w: invalid tries %q", ErrMalformedHeader, firstLineValues[2])
+		}
+		if tries < 1 || tries > p.MaxTries() {
+			return AngleEntry{}, fmt.Errorf("%w: %d", ErrTriesOutOfRange, tries)
+		}
+	}
+
+	return AngleEntry{Game: WordleGame, UserId: authorId, GlobalName: authorName, AngleIssue: issue, Tries: tries, Completed: completed}, nil
+}
+
+type ConnectionsParser struct{}
+
+const connectionsGroups = 4
+
+func (ConnectionsParser) Name() string    { return ConnectionsGame }
+func (ConnectionsParser) Example() string { return "Connections\nPuzzle #123" }
+func (ConnectionsParser) MaxTries() int   { return connectionsGroups + 3 }
+func (ConnectionsParser) TodayIssue() int { return issueSince(1, 2023, time.June, 12) }
+
+func (ConnectionsParser) IssueDate(issue int) time.Time {
+	return issueDate(1, 2023, time.June, 12, issue)
+}
+
+var connectionsHeader = regexp.MustCompile(`^Connections\r?\n[ \t]*Puzzle #`)
+
+func (ConnectionsParser) Detect(message string) bool {
+	return connectionsHeader.MatchString(message)
+}
+
+func (p ConnectionsParser) Parse(message string, authorId string, authorName string) (AngleEntry, error) {
+	lines := strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n")
+	if len(lines) < 2 {
+		return AngleEntry{}, fmt.Errorf("%w: missing puzzle number", ErrMalformedHeader)
+	}
+
+	puzzleStr, found := strings.CutPrefix(strings.TrimSpace(lines[1]), "Puzzle #")
+	if !found {
+		return AngleEntry{}, fmt.Errorf("%w: %q", ErrMalformedHeader, lines[1])
+	}
+	issue, err := strconv.Atoi(puzzleStr)
+	if err != nil {
+		return AngleEntry{}, fmt.Errorf("%w: invalid issue %q", ErrMalformedHeader, puzzleStr)
+	}
+	if err := validateIssue(issue, p.TodayIssue()); err != nil {
+		return AngleEntry{}, err
+	}
+
+	// Every row of squares is a guess, a guess is right when all its squares
+	// share the same color
+	tries := 0
+	solved := 0
+	for _, line := range lines[2:] {
+		squares := []rune(strings.TrimSpace(line))
+		if len(squares) != connectionsGroups {
+			continue
+		}
+		tries++
+		if strings.Count(string(squares), string(squares[0])) == connectionsGroups {
+			solved++
+		}
+	}
+	if tries < 1 || tries > p.MaxTries() {
+		return AngleEntry{}, fmt.Errorf("%w: %d", ErrTriesOutOfRange, tries)
+	}
+
+	completed := 0
+	if solved == connectionsGroups {
+		completed = 1
+	}
+	return AngleEntry{Game: ConnectionsGame, UserId: authorId, GlobalName: authorName, AngleIssue: issue, Tries: tries, Completed: completed}, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestDetectGame(t *testing.T) {
+	tests := []struct {
+		message string
+		game    string
+	}{
+		{"#Angle #1106 2/4\n⬆️🎉", AngleGame},
+		{"Wordle 1,473 4/6\n\n⬛🟨⬛⬛⬛", WordleGame},
+		{"Wordle 1,473 X/6*", WordleGame},
+		{"Wordle 473 7/6", WordleGame},
+		{"Connections\nPuzzle #751\n🟨🟨🟨🟨", ConnectionsGame},
+		{"Connections\r\nPuzzle #751", ConnectionsGame},
+		// Plain chat
+		{"Wordle is hard today", ""},
+		{"Wordle 1,473 was hard", ""},
+		{"Connections are fun", ""},
+		{"Connections\nwas easy today", ""},
+		{"I got Wordle 1,473 4/6", ""},
+	}
+
+	for _, test := range tests {
+		game := ""
+		if gameParser, ok := DetectGame(test.message); ok {
+			game = gameParser.Name()
+		}
+		if game != test.game {
+			t.Errorf("DetectGame(%q) is %q, want %q", test.message, game, test.game)
+		}
+	}
+}
@@ -6,11 +6,9 @@ toolchain go1.24.4
 
 require (
 	github.com/bwmarrin/discordgo v0.29.0
-)
-
-require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.28
 )
 
 require (
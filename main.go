@@ -1,6 +1,8 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -15,26 +17,72 @@ import (
 )
 
 var (
-	BotToken  = flag.String("token", "", "Bot access token")
-	ChannelId = flag.String("channel", "", "Channel to send reminder")
+	BotToken      = flag.String("token", "", "Bot access token")
+	ChannelId     = flag.String("channel", "", "Channel to send reminder")
+	ReminderGames = flag.String("reminder-games", AngleGame, "Comma separated list of games to send reminders for")
+	DatabaseDsn   = flag.String("db", "./foo.db", "SQLite database path or DSN, or a postgres:// DSN")
+	Timezone      = flag.String("timezone", "-05:00", "Timezone of the angle calendar, an IANA name or an offset like -05:00")
+	Rollover      = flag.String("rollover", "07:00", "Time of the day a new angle issue comes out in -timezone")
+
+	BackupDir         = flag.String("backup-dir", "./backups", "Directory to write SQLite backups to")
+	BackupSchedule    = flag.String("backup-schedule", "0 4 * * *", "Cron schedule of the SQLite backups, empty to turn them off")
+	BackupKeepDaily   = flag.Int("backup-keep-daily", 7, "Number of days to keep a daily backup for")
+	BackupKeepMonthly = flag.Int("backup-keep-monthly", 12, "Number of months to keep a monthly backup for")
 )
 
 var s *discordgo.Session
 
-func init() { flag.Parse() }
+var store Repository
 
-func init() {
-	var err error
-	s, err = discordgo.New("Bot " + *BotToken)
+// Guilds pick the channel their reminders are sent to with this setting, off
+// turns them off
+const ReminderChannelSetting = "reminder_channel"
+
+// reminderChannels returns the channel each guild gets its reminders in. The
+// guild of the -channel flag uses it unless it picked its own channel.
+func reminderChannels() (map[string]string, error) {
+	channels, err := store.ListGuildSettings(ReminderChannelSetting)
 	if err != nil {
-		log.Fatalf("Invalid bot parameters: %v", err)
+		return nil, err
+	}
+	if *ChannelId != "" {
+		channel, err := s.Channel(*ChannelId)
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching reminder channel %s: %w", *ChannelId, err)
+		}
+		if _, ok := channels[channel.GuildID]; !ok {
+			channels[channel.GuildID] = channel.ID
+		}
 	}
+	return channels, nil
 }
 
-func sendReminderMessage(channelId string) {
-	userIds := GetUsersIds()
-	todayAngleIssue := GetTodayAngleIssue()
-	usersTodayAngleDone := GetUserIdsAngleIssueDone(todayAngleIssue)
+func sendReminderMessages(game GameParser) {
+	channels, err := reminderChannels()
+	if err != nil {
+		log.Printf("Error sending %s reminders: %v", game.Name(), err)
+		return
+	}
+	for guildId, channelId := range channels {
+		if channelId == "off" {
+			continue
+		}
+		sendReminderMessage(guildId, channelId, game)
+	}
+}
+
+func sendReminderMessage(guildId string, channelId string, game GameParser) {
+	userIds, err := store.GetUsersIds(guildId, game.Name())
+	if err != nil {
+		log.Printf("Error sending %s reminder: %v", game.Name(), err)
+		return
+	}
+	todayAngleIssue := game.TodayIssue()
+	usersTodayAngleDone, err := store.GetUserIdsAngleIssueDone(guildId, game.Name(), todayAngleIssue)
+	if err != nil {
+		log.Printf("Error sending %s reminder: %v", game.Name(), err)
+		return
+	}
 	userIdsMissingleTodayAngle := []string{}
 	for _, userId := range userIds {
 		if !slices.Contains(usersTodayAngleDone, userId) {
@@ -44,38 +92,116 @@ func sendReminderMessage(channelId string) {
 
 	message := ""
 	if len(userIds) == len(userIdsMissingleTodayAngle) {
-		message = "No one has tried guessing today's angle yet!"
+		message = fmt.Sprintf("No one has tried today's %s yet!", game.Name())
 	} else if len(userIdsMissingleTodayAngle) > 0 {
-		message = "Remember to do today's angle!"
+		message = fmt.Sprintf("Remember to do today's %s!", game.Name())
 		for _, userId := range userIdsMissingleTodayAngle {
 			message += fmt.Sprintf(" <@%s>", userId)
 		}
 	}
 
 	if len(message) > 0 {
-		log.Println("Sending reminder for Issue ", todayAngleIssue)
+		log.Println("Sending", game.Name(), "reminder for Issue", todayAngleIssue, "to guild", guildId)
 		s.ChannelMessageSend(channelId, message)
 	}
 }
 
 func startCronJobs() {
 	c := cron.New()
-	c.AddFunc("0 1,13,17,21 * * *", func() { sendReminderMessage(*ChannelId) })
+	for _, gameName := range strings.Split(*ReminderGames, ",") {
+		game, ok := GetGameParser(strings.TrimSpace(gameName))
+		if !ok {
+			log.Printf("Unknown game %q in reminder games", gameName)
+			continue
+		}
+		c.AddFunc("0 1,13,17,21 * * *", func() { sendReminderMessages(game) })
+	}
+
+	if _, ok := store.(BackupStore); ok && *BackupSchedule != "" {
+		_, err := c.AddFunc(*BackupSchedule, func() {
+			_, err := CreateBackup()
+			if err != nil {
+				log.Println(err)
+			}
+		})
+		if err != nil {
+			log.Printf("Invalid backup schedule %q: %v", *BackupSchedule, err)
+		}
+	}
 	c.Start()
 }
 
 func main() {
+	// Flags are parsed here and not in init so tests can be run
+	flag.Parse()
+
+	var err error
+	s, err = discordgo.New("Bot " + *BotToken)
+	if err != nil {
+		log.Fatalf("Invalid bot parameters: %v", err)
+	}
+
+	calendar, err = NewCalendar(*Timezone, *Rollover)
+	if err != nil {
+		log.Fatalf("Invalid calendar parameters: %v", err)
+	}
+
+	store, err = OpenStore(*DatabaseDsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if flag.Arg(0) == "migrate" {
+		err = runMigrateCommand(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// Snapshots can be older than the current schema, they are migrated the
+	// next time the bot starts
+	if flag.Arg(0) == "restore" {
+		err = runRestoreCommand(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	_, err = store.Migrate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.NArg() > 0 {
+		err = runCommand(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	startCronJobs()
-	CreateTables()
+	rated, err := store.RecomputeRatings(true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rated > 0 {
+		log.Printf("Rated %d games that had no ratings", rated)
+	}
 
 	// Register the messageCreate func as a callback for MessageCreate events.
 	s.AddHandler(messageCreate)
+	s.AddHandler(messageUpdate)
+	s.AddHandler(messageDelete)
+	s.AddHandler(messageDeleteBulk)
 
 	// In this example, we only care about receiving message events.
 	s.Identify.Intents = discordgo.IntentsGuildMessages
 
 	// Open a websocket connection to Discord and begin listening.
-	err := s.Open()
+	err = s.Open()
 	if err != nil {
 		fmt.Println("error opening connection,", err)
 		return
@@ -90,6 +216,41 @@ func main() {
 	s.Close()
 }
 
+// runCommand runs the bot in command line mode, doing a single task and
+// exiting instead of listening for messages.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "backfill":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: backfill <channel id>")
+		}
+		report, err := BackfillChannel(s, args[1])
+		fmt.Println(report)
+		return err
+	case "recompute-seasons":
+		changed, err := store.RecomputeAllSeasons()
+		fmt.Printf("Changed the season of %d entries\n", changed)
+		return err
+	case "assign-guild":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: assign-guild <guild id>")
+		}
+		assigned, err := store.AssignGuild(args[1])
+		fmt.Printf("Assigned %d entries to guild %s\n", assigned, args[1])
+		if err != nil {
+			return err
+		}
+		// The entries it got change the ratings of the guild
+		_, err = store.RecomputeRatings(false)
+		return err
+	case "recompute-ratings":
+		rated, err := store.RecomputeRatings(false)
+		fmt.Printf("Rated %d games\n", rated)
+		return err
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
 // This function will be called (due to AddHandler above) every time a new
 // message is created on any channel that the authenticated bot has access to.
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -98,29 +259,192 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
+	refreshUser(m.GuildID, m.Author, m.Member)
 
-	if strings.HasPrefix(m.Content, "#Angle") {
-		angleEntry := ParseAngleEntry(m.Content, m.Author.ID, m.Author.GlobalName)
-		InsertAngleTryEntry(angleEntry)
-		s.MessageReactionAdd(m.ChannelID, m.Message.ID, GetEntryEmojiReaction(angleEntry.Completed, angleEntry.Tries, m))
+	if game, ok := DetectGame(m.Content); ok {
+		angleEntry, err := parseEntryMessage(s, game, m.Message)
+		if err != nil {
+			return
+		}
+		submitEntryMessage(s, m.Message, angleEntry)
 	} else if strings.HasPrefix(m.Content, "!standings") {
-		s.ChannelMessageSend(m.ChannelID, GetStandingMessage(m.Content))
+		s.ChannelMessageSend(m.ChannelID, GetStandingMessage(m.Content, m.GuildID))
 	} else if strings.HasPrefix(m.Content, "!stats") {
 		user := m.Author
 		if len(m.Mentions) > 0 {
 			user = m.Mentions[0]
 		}
-		s.ChannelMessageSend(m.ChannelID, GetStatsMessage(m.Content, user.ID))
+		s.ChannelMessageSend(m.ChannelID, GetStatsMessage(m.Content, m.GuildID, user.ID))
+	} else if strings.HasPrefix(m.Content, "!rating") {
+		user := m.Author
+		if len(m.Mentions) > 0 {
+			user = m.Mentions[0]
+		}
+		s.ChannelMessageSend(m.ChannelID, GetRatingMessage(m.Content, m.GuildID, user.ID))
+	} else if strings.HasPrefix(m.Content, "!join") {
+		s.ChannelMessageSend(m.ChannelID, GetParticipationMessage(m.Content, m.GuildID, m.Author.ID, true))
+	} else if strings.HasPrefix(m.Content, "!leave") {
+		s.ChannelMessageSend(m.ChannelID, GetParticipationMessage(m.Content, m.GuildID, m.Author.ID, false))
 	} else if strings.HasPrefix(m.Content, "!transportador") {
 		user := m.Author
 		if len(m.Mentions) > 0 {
 			user = m.Mentions[0]
 		}
-		oneGuessEntries := CountOneGuessEntries(user.ID, user.GlobalName)
-		s.ChannelMessageSend(m.ChannelID, oneGuessEntries)
+		s.ChannelMessageSend(m.ChannelID, GetTransportadorMessage(m.GuildID, user.ID))
 	} else if strings.HasPrefix(m.Content, "!failquotes") {
-		s.ChannelMessageSend(m.ChannelID, GetFailQuoteActionResultMessage(m.Content, m.GuildID))
+		s.ChannelMessageSend(m.ChannelID, GetFailQuoteActionResultMessage(m.Content, m.GuildID, m.Author.ID))
 	} else if strings.HasPrefix(m.Content, "!corralazos") {
-		s.ChannelMessageSend(m.ChannelID, GetSeasonWinCount())
+		s.ChannelMessageSend(m.ChannelID, GetSeasonWinCount(m.Content, m.GuildID))
+	} else if strings.HasPrefix(m.Content, "!seasons") {
+		s.ChannelMessageSend(m.ChannelID, GetSeasonsMessage(s, m))
+	} else if strings.HasPrefix(m.Content, "!scoring") {
+		s.ChannelMessageSend(m.ChannelID, GetScoringMessage(s, m))
+	} else if strings.HasPrefix(m.Content, "!settings") {
+		s.ChannelMessageSend(m.ChannelID, GetSettingsMessage(s, m))
+	} else if strings.HasPrefix(m.Content, "!backfill") {
+		s.ChannelMessageSend(m.ChannelID, GetBackfillMessage(s, m))
+	} else if strings.HasPrefix(m.Content, "!audit") {
+		s.ChannelMessageSend(m.ChannelID, GetAuditMessage(s, m))
+	} else if strings.HasPrefix(m.Content, "!backup") {
+		s.ChannelMessageSend(m.ChannelID, GetBackupMessage(s, m))
+	}
+}
+
+// This function will be called every time a message is edited, so fixing a
+// typo in a posted result updates the stored entry.
+func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	// Partial updates like embeds being resolved come without an author
+	if m.Author == nil || m.Author.ID == s.State.User.ID {
+		return
+	}
+
+	game, ok := DetectGame(m.Content)
+	if !ok {
+		return
+	}
+
+	angleEntry, err := parseEntryMessage(s, game, m.Message)
+	if err != nil {
+		return
+	}
+
+	previousEntry, err := store.GetAngleEntryByMessageId(m.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The original message wasn't a valid result
+		log.Printf("Inserting edited %s entry from %s", game.Name(), m.Author.ID)
+		submitEntryMessage(s, m.Message, angleEntry)
+		return
+	} else if err != nil {
+		log.Printf("Error looking up entry for message %s: %v", m.ID, err)
+		return
+	}
+
+	if sameResult(angleEntry, previousEntry) {
+		return
+	}
+
+	angleEntry.Id = previousEntry.Id
+	err = retryBusy(func() error {
+		return store.UpdateAngleTryEntry(angleEntry)
+	})
+	// The edit moved it to an issue the user already has a result for
+	if isUniqueViolation(err) {
+		s.ChannelMessageSendReply(m.ChannelID, duplicateEntryMessage(RejectPolicy, angleEntry), m.Reference())
+		return
+	} else if err != nil {
+		log.Printf("Error updating entry for message %s: %v", m.ID, err)
+		s.ChannelMessageSendReply(m.ChannelID, "Couldn't update your result, try again", m.Reference())
+		return
+	}
+	log.Printf("Updated %s entry %s from %s", game.Name(), angleEntry.Id, m.Author.ID)
+
+	previousEmoji := GetEntryEmojiReaction(previousEntry.Completed, previousEntry.Tries)
+	emoji := GetEntryEmojiReaction(angleEntry.Completed, angleEntry.Tries)
+	if previousEmoji != emoji {
+		s.MessageReactionRemove(m.ChannelID, m.ID, previousEmoji, "@me")
+		s.MessageReactionAdd(m.ChannelID, m.ID, emoji)
+		if angleEntry.Completed == 0 && previousEntry.Completed == 1 {
+			SendFailQuote(m.Message)
+		}
+	}
+}
+
+// sameResult reports if an edited message still has the result stored for it,
+// like when only the text around it changed.
+func sameResult(angleEntry AngleEntry, previousEntry AngleEntry) bool {
+	return angleEntry.Game == previousEntry.Game && angleEntry.AngleIssue == previousEntry.AngleIssue && angleEntry.Tries == previousEntry.Tries && angleEntry.OffBy == previousEntry.OffBy && angleEntry.Completed == previousEntry.Completed && slices.Equal(angleEntry.Guesses, previousEntry.Guesses)
+}
+
+// Deleting a posted result takes it out of the standings.
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	retractEntryMessage(m.ID)
+}
+
+func messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	for _, messageId := range m.Messages {
+		retractEntryMessage(messageId)
+	}
+}
+
+func retractEntryMessage(messageId string) {
+	angleEntry, err := store.GetAngleEntryByMessageId(messageId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	} else if err != nil {
+		log.Printf("Error looking up entry for message %s: %v", messageId, err)
+		return
+	}
+
+	err = retryBusy(func() error {
+		// Discord doesn't tell who deleted the message
+		return store.RetractAngleTryEntry(angleEntry.Id, "")
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("Retracted %s entry %s for issue %d from %s, message %s was deleted", angleEntry.Game, angleEntry.Id, angleEntry.AngleIssue, angleEntry.UserId, messageId)
+}
+
+// submitEntryMessage stores the entry of a message and reacts to it, or tells
+// the author why it wasn't stored.
+func submitEntryMessage(s *discordgo.Session, m *discordgo.Message, angleEntry AngleEntry) {
+	var stored bool
+	var reason string
+	err := retryBusy(func() error {
+		var err error
+		stored, reason, err = SubmitAngleEntry(angleEntry)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error storing %s entry from %s: %v", angleEntry.Game, m.Author.ID, err)
+		s.ChannelMessageSendReply(m.ChannelID, "Couldn't save your result, try again", m.Reference())
+		return
+	}
+	if !stored {
+		log.Printf("Not storing %s entry from %s: %s", angleEntry.Game, m.Author.ID, reason)
+		s.ChannelMessageSendReply(m.ChannelID, reason, m.Reference())
+		return
+	}
+
+	if angleEntry.Completed == 0 {
+		SendFailQuote(m)
+	}
+	s.MessageReactionAdd(m.ChannelID, m.ID, GetEntryEmojiReaction(angleEntry.Completed, angleEntry.Tries))
+}
+
+// parseEntryMessage parses a game result message, replying to the author when
+// the result can't be read.
+func parseEntryMessage(s *discordgo.Session, game GameParser, m *discordgo.Message) (AngleEntry, error) {
+	angleEntry, err := game.Parse(m.Content, m.Author.ID, m.Author.GlobalName)
+	if err != nil {
+		log.Printf("Rejected %s entry from %s: %v", game.Name(), m.Author.ID, err)
+		s.ChannelMessageSendReply(m.ChannelID, GetParseErrorMessage(game, err), m.Reference())
+		return AngleEntry{}, err
 	}
+	angleEntry.MessageId = m.ID
+	angleEntry.GuildId = m.GuildID
+	angleEntry.ChannelId = m.ChannelID
+	angleEntry.SubmittedAt = m.Timestamp
+	return angleEntry, nil
 }
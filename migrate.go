@@ -0,0 +1,219 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change, read from
+// migrations/<dialect>/NNNN_name.sql. Every backend has the same migrations
+// written in its own SQL.
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Databases created before migrations existed had their columns added by
+// hand, adding a column that is already there is skipped so those databases
+// can be migrated like new ones.
+var addColumnStatement = regexp.MustCompile(`(?i)^ALTER TABLE (\w+) ADD COLUMN (\w+)`)
+
+func loadMigrations(d dialect) ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, path.Join("migrations", d.Name, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	for _, file := range files {
+		versionStr, name, found := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), "_")
+		version, err := strconv.Atoi(versionStr)
+		if !found || err != nil {
+			return nil, fmt.Errorf("Invalid migration file name %s", file)
+		}
+
+		content, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, Statements: splitStatements(string(content))})
+	}
+	// Glob returns the files sorted by name
+	return migrations, nil
+}
+
+func splitStatements(content string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	statements := []string{}
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+func (st *Store) appliedMigrations() (map[int]bool, error) {
+	_, err := st.db.Exec("CREATE TABLE IF NOT EXISTS schema_version(version integer not null primary key, name text, applied_at bigint)")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating schema_version table: %w", err)
+	}
+
+	rows, err := st.db.Query("select version from schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (st *Store) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(st.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := st.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := []MigrationStatus{}
+	for _, migration := range migrations {
+		status = append(status, MigrationStatus{Migration: migration, Applied: applied[migration.Version]})
+	}
+	return status, nil
+}
+
+// Migrate applies every pending migration in order and returns how many were
+// applied.
+func (st *Store) Migrate() (int, error) {
+	status, err := st.MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range status {
+		if migration.Applied {
+			continue
+		}
+		err = st.applyMigration(migration.Migration)
+		if err != nil {
+			return count, fmt.Errorf("Error applying migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+		count++
+	}
+	return count, nil
+}
+
+func (st *Store) applyMigration(migration Migration) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range migration.Statements {
+		if match := addColumnStatement.FindStringSubmatch(statement); match != nil {
+			exists, err := columnExists(tx, match[1], match[2])
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, statement)
+		}
+	}
+
+	_, err = tx.Exec("insert into schema_version(version, name, applied_at) values(?, ?, ?)", migration.Version, migration.Name, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func columnExists(tx *sqlTx, table string, column string) (bool, error) {
+	rows, err := tx.Query(tx.dialect.ColumnsQuery, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return false, err
+		}
+		if strings.EqualFold(name, column) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func runMigrateCommand(args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "status":
+		status, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, migration := range status {
+			state := "pending"
+			if migration.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s %s\n", migration.Version, migration.Name, state)
+		}
+		return nil
+	case "up":
+		count, err := store.Migrate()
+		fmt.Printf("Applied %d migrations\n", count)
+		return err
+	}
+	return fmt.Errorf("usage: migrate status|up")
+}
@@ -0,0 +1,4 @@
+-- rowid mirrors the implicit rowid of SQLite, it keeps entries in the order
+-- they were inserted
+CREATE TABLE IF NOT EXISTS angle_tries(id text not null primary key, rowid bigserial, user_id text, global_name text, angle_issue integer, tries integer, off_by integer, completed integer);
+CREATE TABLE IF NOT EXISTS fail_quotes(id text not null primary key, guild_id text, quote text);
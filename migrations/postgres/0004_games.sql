@@ -0,0 +1 @@
+ALTER TABLE angle_tries ADD COLUMN game text not null default 'angle';
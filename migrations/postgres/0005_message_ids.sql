@@ -0,0 +1,2 @@
+ALTER TABLE angle_tries ADD COLUMN message_id text;
+CREATE INDEX IF NOT EXISTS angle_tries_message_id ON angle_tries(message_id);
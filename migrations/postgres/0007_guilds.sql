@@ -0,0 +1,2 @@
+ALTER TABLE angle_tries ADD COLUMN guild_id text;
+CREATE TABLE IF NOT EXISTS guild_settings(guild_id text not null, key text not null, value text, primary key(guild_id, key));
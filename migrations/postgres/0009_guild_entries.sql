@@ -0,0 +1,8 @@
+ALTER TABLE angle_tries ADD COLUMN channel_id text;
+-- Entries stored before guilds were tracked go to the guild with the most
+-- entries and fail quotes, unless the user already has a result for the same
+-- issue there. Any other guild can be picked with the assign-guild command.
+UPDATE angle_tries SET guild_id = (SELECT g.guild_id FROM (SELECT guild_id FROM angle_tries WHERE guild_id <> '' UNION ALL SELECT guild_id FROM fail_quotes WHERE guild_id <> '') g GROUP BY g.guild_id ORDER BY count(*) DESC, g.guild_id LIMIT 1)
+WHERE coalesce(guild_id, '') = '' AND (deleted_at IS NOT NULL OR NOT EXISTS (SELECT 1 FROM angle_tries b WHERE b.guild_id = (SELECT g.guild_id FROM (SELECT guild_id FROM angle_tries WHERE guild_id <> '' UNION ALL SELECT guild_id FROM fail_quotes WHERE guild_id <> '') g GROUP BY g.guild_id ORDER BY count(*) DESC, g.guild_id LIMIT 1) AND b.user_id = angle_tries.user_id AND b.game = angle_tries.game AND b.angle_issue = angle_tries.angle_issue AND b.deleted_at IS NULL));
+UPDATE fail_quotes SET guild_id = (SELECT g.guild_id FROM (SELECT guild_id FROM angle_tries WHERE guild_id <> '' UNION ALL SELECT guild_id FROM fail_quotes WHERE guild_id <> '') g GROUP BY g.guild_id ORDER BY count(*) DESC, g.guild_id LIMIT 1) WHERE coalesce(guild_id, '') = '';
+CREATE INDEX IF NOT EXISTS angle_tries_guild ON angle_tries(guild_id, game, season);
@@ -0,0 +1,3 @@
+-- rowid keeps changes made in the same second in order, like in SQLite
+CREATE TABLE IF NOT EXISTS audit_log(id text not null primary key, rowid bigserial, guild_id text not null default '', actor_id text not null default '', action text not null, target_id text, before_value text, after_value text, created_at bigint not null);
+CREATE INDEX IF NOT EXISTS audit_log_guild ON audit_log(guild_id, created_at);
@@ -0,0 +1,4 @@
+-- The rating of each player after every issue they played, the current
+-- rating is the one of their latest issue
+CREATE TABLE IF NOT EXISTS ratings(guild_id text not null, game text not null, angle_issue integer not null, user_id text not null, rating double precision not null, matches integer not null, primary key(guild_id, game, user_id, angle_issue));
+CREATE INDEX IF NOT EXISTS ratings_issue ON ratings(guild_id, game, angle_issue);
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS angle_tries(id text not null primary key, user_id text, global_name text, angle_issue integer, tries integer, off_by integer, completed integer);
+CREATE TABLE IF NOT EXISTS fail_quotes(id text not null primary key, guild_id text, quote text);
@@ -0,0 +1,4 @@
+ALTER TABLE angle_tries ADD COLUMN season integer;
+-- Season 2 started with issue 1137, older entries belong to season 1
+UPDATE angle_tries SET season = 1 WHERE season IS NULL AND angle_issue < 1137;
+UPDATE angle_tries SET season = 2 WHERE season IS NULL AND angle_issue >= 1137;
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS angle_guesses(id text not null primary key, entry_id text not null references angle_tries(id), guess_number integer, direction text, off_by integer);
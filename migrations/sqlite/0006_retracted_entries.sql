@@ -0,0 +1 @@
+ALTER TABLE angle_tries ADD COLUMN deleted_at integer;
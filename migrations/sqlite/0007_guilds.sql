@@ -0,0 +1,4 @@
+-- The unique index on entries is created after applying the duplicate
+-- policy of each guild, see 0018_unique_entries.sql
+ALTER TABLE angle_tries ADD COLUMN guild_id text;
+CREATE TABLE IF NOT EXISTS guild_settings(guild_id text not null, key text not null, value text, primary key(guild_id, key));
@@ -0,0 +1,8 @@
+-- Users are keyed by their Discord id, the names seen for them are kept in
+-- user_names, the guild_id is empty for their global display name
+CREATE TABLE IF NOT EXISTS users(id text not null primary key, username text, global_name text, updated_at bigint);
+CREATE TABLE IF NOT EXISTS guild_members(guild_id text not null, user_id text not null, nickname text, updated_at bigint, primary key(guild_id, user_id));
+CREATE TABLE IF NOT EXISTS user_names(user_id text not null, guild_id text not null default '', name text not null, seen_at bigint, primary key(user_id, guild_id, name));
+-- Start from the last name stored with the entries of each user
+INSERT INTO users(id, global_name, updated_at) SELECT user_id, global_name, 0 FROM angle_tries a WHERE rowid = (SELECT max(rowid) FROM angle_tries WHERE user_id = a.user_id);
+INSERT INTO user_names(user_id, guild_id, name, seen_at) SELECT user_id, '', global_name, 0 FROM angle_tries WHERE global_name IS NOT NULL AND global_name <> '' GROUP BY user_id, global_name;
@@ -0,0 +1,5 @@
+ALTER TABLE angle_tries ADD COLUMN submitted_at integer;
+-- The time a message was posted is part of its Discord id
+UPDATE angle_tries SET submitted_at = ((CAST(message_id AS integer) >> 22) + 1420070400000) / 1000 WHERE submitted_at IS NULL AND coalesce(message_id, '') <> '';
+CREATE INDEX IF NOT EXISTS angle_tries_channel ON angle_tries(channel_id, submitted_at);
+CREATE INDEX IF NOT EXISTS angle_tries_submitted_at ON angle_tries(guild_id, submitted_at);
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS audit_log(id text not null primary key, guild_id text not null default '', actor_id text not null default '', action text not null, target_id text, before_value text, after_value text, created_at integer not null);
+CREATE INDEX IF NOT EXISTS audit_log_guild ON audit_log(guild_id, created_at);
@@ -0,0 +1,6 @@
+-- Standings and stats are counted from these indexes without reading the
+-- entries themselves
+DROP INDEX IF EXISTS angle_tries_guild;
+CREATE INDEX IF NOT EXISTS angle_tries_scores ON angle_tries(guild_id, game, season, user_id, completed, tries) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS angle_tries_all_scores ON angle_tries(guild_id, game, user_id, completed, tries) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS angle_tries_user_stats ON angle_tries(guild_id, user_id, game, angle_issue, season, tries, completed) WHERE deleted_at IS NULL;
@@ -0,0 +1,6 @@
+-- Each version of the rules of a guild applies from its season until the
+-- season of the next version
+CREATE TABLE IF NOT EXISTS scoring_rules(guild_id text not null, from_season integer not null, rules text not null, primary key(guild_id, from_season));
+-- Standings of all seasons are scored season by season
+DROP INDEX IF EXISTS angle_tries_all_scores;
+CREATE INDEX IF NOT EXISTS angle_tries_all_scores ON angle_tries(guild_id, game, user_id, season, completed, tries) WHERE deleted_at IS NULL;
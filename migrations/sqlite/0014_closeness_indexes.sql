@@ -0,0 +1,7 @@
+-- Closeness scoring reads how far off each result was
+DROP INDEX IF EXISTS angle_tries_scores;
+DROP INDEX IF EXISTS angle_tries_all_scores;
+DROP INDEX IF EXISTS angle_tries_user_stats;
+CREATE INDEX IF NOT EXISTS angle_tries_scores ON angle_tries(guild_id, game, season, user_id, completed, tries, off_by) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS angle_tries_all_scores ON angle_tries(guild_id, game, user_id, season, completed, tries, off_by) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS angle_tries_user_stats ON angle_tries(guild_id, user_id, game, angle_issue, season, tries, off_by, completed) WHERE deleted_at IS NULL;
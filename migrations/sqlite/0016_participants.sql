@@ -0,0 +1,3 @@
+-- The stretches of issues each user signed up to play, left_issue is null
+-- while they're still in
+CREATE TABLE IF NOT EXISTS participants(guild_id text not null, game text not null, user_id text not null, joined_issue integer not null, left_issue integer, primary key(guild_id, game, user_id, joined_issue));
@@ -0,0 +1,3 @@
+-- Seasons of each guild as ranges of angle issues, the ones missing are
+-- generated with the cadence of the guild when they're first needed
+CREATE TABLE IF NOT EXISTS seasons(guild_id text not null, number integer not null, name text not null, start_issue integer not null, end_issue integer not null, primary key(guild_id, number));
@@ -0,0 +1,11 @@
+-- Only one entry per user and issue counts. Duplicates stored before that was
+-- enforced are retracted following the duplicate policy of their guild,
+-- keep_best keeps the best result and any other policy the first one posted.
+-- The guild is coalesced since entries stored before guilds were tracked
+-- don't have one.
+UPDATE angle_tries SET deleted_at = CAST(strftime('%s', 'now') AS integer)
+WHERE deleted_at IS NULL AND EXISTS (SELECT 1 FROM angle_tries k WHERE k.deleted_at IS NULL AND k.rowid <> angle_tries.rowid AND coalesce(k.guild_id, '') = coalesce(angle_tries.guild_id, '') AND k.user_id = angle_tries.user_id AND k.game = angle_tries.game AND k.angle_issue = angle_tries.angle_issue
+AND CASE WHEN (SELECT value FROM guild_settings WHERE guild_id = coalesce(angle_tries.guild_id, '') AND key = 'duplicates') = 'keep_best'
+THEN k.completed > angle_tries.completed OR (k.completed = angle_tries.completed AND (k.tries < angle_tries.tries OR (k.tries = angle_tries.tries AND (k.off_by < angle_tries.off_by OR (k.off_by = angle_tries.off_by AND k.rowid < angle_tries.rowid)))))
+ELSE k.rowid < angle_tries.rowid END);
+CREATE UNIQUE INDEX IF NOT EXISTS angle_tries_unique_issue ON angle_tries((coalesce(guild_id, '')), user_id, game, angle_issue) WHERE deleted_at IS NULL;
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+const (
+	AuditParticipantJoin  = "participant_join"
+	AuditParticipantLeave = "participant_leave"
+)
+
+// Participation is a stretch of issues a user signed up to play, LeftIssue is
+// 0 while they're still in. Only registered participants can miss an issue.
+type Participation struct {
+	UserId      string
+	JoinedIssue int
+	LeftIssue   int
+}
+
+// JoinParticipant signs a user up for a game from an issue on, it returns
+// false if they were already in.
+func (st *Store) JoinParticipant(guildId string, game string, userId string, issue int) (bool, error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var open int
+	err = tx.QueryRow("select count(*) from participants where guild_id = ? and game = ? and user_id = ? and left_issue is null", guildId, game, userId).Scan(&open)
+	if err != nil {
+		return false, fmt.Errorf("Error reading participation of %s: %w", userId, err)
+	}
+	if open > 0 {
+		return false, nil
+	}
+
+	// Leaving and joining again on the same issue picks up the same stretch
+	_, err = tx.Exec("insert into participants(guild_id, game, user_id, joined_issue) values(?, ?, ?, ?) on conflict(guild_id, game, user_id, joined_issue) do update set left_issue = null", guildId, game, userId, issue)
+	if err != nil {
+		return false, fmt.Errorf("Error saving participation of %s: %w", userId, err)
+	}
+
+	err = insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: userId, Action: AuditParticipantJoin, TargetId: game, After: strconv.Itoa(issue)})
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// LeaveParticipant ends the participation of a user in a game, issues from
+// issue on are no longer missed. It returns false if they weren't in.
+func (st *Store) LeaveParticipant(guildId string, game string, userId string, issue int) (bool, error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("update participants set left_issue = ? where guild_id = ? and game = ? and user_id = ? and left_issue is null", issue, guildId, game, userId)
+	if err != nil {
+		return false, fmt.Errorf("Error ending participation of %s: %w", userId, err)
+	}
+	if left, err := result.RowsAffected(); err != nil || left == 0 {
+		return false, err
+	}
+
+	err = insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: userId, Action: AuditParticipantLeave, TargetId: game, After: strconv.Itoa(issue)})
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (st *Store) listParticipations(guildId string, game string) ([]Participation, error) {
+	rows, err := st.db.Query("select user_id, joined_issue, coalesce(left_issue, 0) from participants where guild_id = ? and game = ? order by user_id, joined_issue", guildId, game)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s participants of guild %s: %w", game, guildId, err)
+	}
+	defer rows.Close()
+
+	participations := []Participation{}
+	for rows.Next() {
+		participation := Participation{}
+		err = rows.Scan(&participation.UserId, &participation.JoinedIssue, &participation.LeftIssue)
+		if err != nil {
+			return nil, err
+		}
+		participations = append(participations, participation)
+	}
+	return participations, rows.Err()
+}
+
+// countMissedIssues counts by user and season the issues registered
+// participants didn't play. The issue of today isn't missed until it's over.
+func (st *Store) countMissedIssues(guildId string, game string, season int, allSeasons bool) (map[string]map[int]int, error) {
+	participations, err := st.listParticipations(guildId, game)
+	if err != nil || len(participations) == 0 {
+		return nil, err
+	}
+
+	gameParser, ok := GetGameParser(game)
+	if !ok {
+		gameParser = AngleParser{}
+	}
+	todayIssue := gameParser.TodayIssue()
+	seasons, err := st.ListSeasons(guildId)
+	if err != nil {
+		return nil, err
+	}
+	if len(seasons) == 0 {
+		seasons = []Season{{}}
+	}
+	// Seasons are ranges of angle issues, issues of every game come out daily
+	// so they are all shifted by the same amount
+	offset := toAngleIssue(game, todayIssue) - todayIssue
+
+	// Every issue of a participation is missed unless it was played
+	missed := map[string]map[int]int{}
+	for _, participation := range participations {
+		lastIssue := todayIssue
+		if participation.LeftIssue > 0 {
+			lastIssue = min(lastIssue, participation.LeftIssue)
+		}
+		for i, issueSeason := range seasons {
+			if !allSeasons && issueSeason.Number != season {
+				continue
+			}
+			// The first season has the issues before it and a season goes
+			// on until the next one starts
+			start, end := participation.JoinedIssue, lastIssue
+			if i > 0 {
+				start = max(start, issueSeason.StartIssue-offset)
+			}
+			if i < len(seasons)-1 {
+				end = min(end, seasons[i+1].StartIssue-offset)
+			}
+			if end <= start {
+				continue
+			}
+			if missed[participation.UserId] == nil {
+				missed[participation.UserId] = map[int]int{}
+			}
+			missed[participation.UserId][issueSeason.Number] += end - start
+		}
+	}
+
+	query := "select p.user_id, coalesce(t.season, 0), count(*) from participants p join angle_tries t on t.guild_id = p.guild_id and t.game = p.game and t.user_id = p.user_id and t.angle_issue >= p.joined_issue and t.angle_issue < coalesce(p.left_issue, ?) and t.angle_issue < ? and t.deleted_at is null where p.guild_id = ? and p.game = ?"
+	args := []any{todayIssue, todayIssue, guildId, game}
+	if !allSeasons {
+		query += " and t.season = ?"
+		args = append(args, season)
+	}
+	rows, err := st.db.Query(query+" group by p.user_id, coalesce(t.season, 0)", args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s issues played in guild %s: %w", game, guildId, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId string
+		var playedSeason int
+		var played int
+		err = rows.Scan(&userId, &playedSeason, &played)
+		if err != nil {
+			return nil, err
+		}
+		if missed[userId] == nil {
+			continue
+		}
+		missed[userId][playedSeason] -= played
+		if missed[userId][playedSeason] <= 0 {
+			delete(missed[userId], playedSeason)
+		}
+	}
+	return missed, rows.Err()
+}
+
+// GetParticipationMessage handles !join and !leave, which sign the author up
+// for a game or take them out of it.
+func GetParticipationMessage(message string, guildId string, userId string, join bool) string {
+	game, _ := parseCommandArgs(message)
+	gameParser, ok := GetGameParser(game)
+	if !ok {
+		gameParser = AngleParser{}
+	}
+	issue := gameParser.TodayIssue()
+
+	if join {
+		joined, err := store.JoinParticipant(guildId, game, userId, issue)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't sign you up, try again"
+		}
+		if !joined {
+			return fmt.Sprintf("You're already playing %s", game)
+		}
+		return fmt.Sprintf("You're playing %s from #%d on, issues you don't post a result for count as missed when the scoring rules say so", game, issue)
+	}
+
+	left, err := store.LeaveParticipant(guildId, game, userId, issue)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't take you out, try again"
+	}
+	if !left {
+		return fmt.Sprintf("You're not playing %s", game)
+	}
+	return fmt.Sprintf("You're no longer playing %s, issues from #%d on aren't missed", game, issue)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	_ "github.com/lib/pq"
+)
+
+var postgresDialect = dialect{
+	Name:                 "postgres",
+	NumberedPlaceholders: true,
+	ColumnsQuery:         "select column_name from information_schema.columns where table_schema = current_schema() and table_name = ?",
+}
+
+type PostgresStore struct {
+	*Store
+}
+
+func OpenPostgresStore(dsn string) (*PostgresStore, error) {
+	st, err := openStore("postgres", dsn, postgresDialect)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStore{Store: st}, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Every issue is a match between everyone in the guild who played it, ratings
+// use Elo with each player facing every other player of the issue.
+const (
+	InitialRating = 1500.0
+	ratingK       = 32.0
+)
+
+type Rating struct {
+	Id      string
+	User    string
+	Rating  float64
+	Matches int
+}
+
+type matchResult struct {
+	UserId    string
+	Tries     int
+	OffBy     int
+	Completed int
+}
+
+// compareMatchResults is negative when a beat b: completing beats failing,
+// then fewer tries win and between fails the closer one wins. Fails of games
+// that don't say how far off they were are a draw.
+func compareMatchResults(a matchResult, b matchResult) int {
+	if a.Completed != b.Completed {
+		return b.Completed - a.Completed
+	}
+	if a.Completed == 1 {
+		return a.Tries - b.Tries
+	}
+	if a.OffBy > 0 && b.OffBy > 0 {
+		return a.OffBy - b.OffBy
+	}
+	return 0
+}
+
+// rateMatch updates the ratings of the players of an issue, all of them are
+// compared with the ratings they had before it.
+func rateMatch(ratings map[string]Rating, results []matchResult) {
+	before := map[string]float64{}
+	for _, result := range results {
+		rating, ok := ratings[result.UserId]
+		if !ok {
+			rating = Rating{Id: result.UserId, Rating: InitialRating}
+		}
+		before[result.UserId] = rating.Rating
+	}
+
+	for _, result := range results {
+		change := 0.0
+		for _, opponent := range results {
+			if opponent.UserId == result.UserId {
+				continue
+			}
+			score := 0.5
+			if order := compareMatchResults(result, opponent); order < 0 {
+				score = 1
+			} else if order > 0 {
+				score = 0
+			}
+			expected := 1 / (1 + math.Pow(10, (before[opponent.UserId]-before[result.UserId])/400))
+			change += score - expected
+		}
+
+		ratings[result.UserId] = Rating{
+			Id:      result.UserId,
+			Rating:  before[result.UserId] + ratingK*change/float64(len(results)-1),
+			Matches: ratings[result.UserId].Matches + 1,
+		}
+	}
+}
+
+// replayRatings rates again every issue of a game in a guild from fromIssue
+// on, starting from the ratings players had before it. It's called in the
+// same transaction that changes the entries.
+func replayRatings(tx *sqlTx, guildId string, game string, fromIssue int) error {
+	if guildId == "" {
+		return nil
+	}
+
+	_, err := tx.Exec("delete from ratings where guild_id = ? and game = ? and angle_issue >= ?", guildId, game, fromIssue)
+	if err != nil {
+		return fmt.Errorf("Error removing %s ratings of guild %s: %w", game, guildId, err)
+	}
+
+	ratings := map[string]Rating{}
+	rows, err := tx.Query("select r.user_id, r.rating, r.matches from ratings r where r.guild_id = ? and r.game = ? and r.angle_issue = (select max(angle_issue) from ratings l where l.guild_id = r.guild_id and l.game = r.game and l.user_id = r.user_id)", guildId, game)
+	if err != nil {
+		return fmt.Errorf("Error reading %s ratings of guild %s: %w", game, guildId, err)
+	}
+	for rows.Next() {
+		rating := Rating{}
+		err = rows.Scan(&rating.Id, &rating.Rating, &rating.Matches)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ratings[rating.Id] = rating
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// Entries are read before writing, Postgres doesn't allow both at once
+	rows, err = tx.Query("select angle_issue, user_id, tries, off_by, completed from angle_tries where guild_id = ? and game = ? and angle_issue >= ? and deleted_at is null order by angle_issue, user_id", guildId, game, fromIssue)
+	if err != nil {
+		return fmt.Errorf("Error reading %s entries of guild %s: %w", game, guildId, err)
+	}
+	issues := []int{}
+	matches := map[int][]matchResult{}
+	for rows.Next() {
+		var issue int
+		result := matchResult{}
+		err = rows.Scan(&issue, &result.UserId, &result.Tries, &result.OffBy, &result.Completed)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if _, ok := matches[issue]; !ok {
+			issues = append(issues, issue)
+		}
+		matches[issue] = append(matches[issue], result)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("insert into ratings(guild_id, game, angle_issue, user_id, rating, matches) values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, issue := range issues {
+		// Nobody to play against
+		if len(matches[issue]) < 2 {
+			continue
+		}
+		rateMatch(ratings, matches[issue])
+		for _, result := range matches[issue] {
+			rating := ratings[result.UserId]
+			_, err = stmt.Exec(guildId, game, issue, rating.Id, rating.Rating, rating.Matches)
+			if err != nil {
+				return fmt.Errorf("Error saving %s rating of %s: %w", game, rating.Id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RecomputeRatings rates every game of every guild again from the first
+// issue, with missingOnly it only rates the games that have no ratings yet.
+// It returns how many games were rated.
+func (st *Store) RecomputeRatings(missingOnly bool) (int, error) {
+	query := "select distinct guild_id, game from angle_tries where guild_id is not null and guild_id != '' and deleted_at is null"
+	if missingOnly {
+		query += " and not exists (select 1 from ratings r where r.guild_id = angle_tries.guild_id and r.game = angle_tries.game)"
+	}
+	rows, err := st.db.Query(query)
+	if err != nil {
+		return 0, fmt.Errorf("Error listing games to rate: %w", err)
+	}
+	type guildGame struct {
+		GuildId string
+		Game    string
+	}
+	games := []guildGame{}
+	for rows.Next() {
+		game := guildGame{}
+		err = rows.Scan(&game.GuildId, &game.Game)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		games = append(games, game)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, game := range games {
+		tx, err := st.db.Begin()
+		if err != nil {
+			return 0, err
+		}
+		err = replayRatings(tx, game.GuildId, game.Game, 0)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(games), nil
+}
+
+// GetRatings returns the current rating of everyone in a guild who played a
+// game with someone else, best first.
+func (st *Store) GetRatings(guildId string, game string) ([]Rating, error) {
+	rows, err := st.db.Query("select a.user_id, "+userDisplayName+", a.rating, a.matches from ratings a join (select user_id, max(angle_issue) as angle_issue from ratings where guild_id = ? and game = ? group by user_id) l on l.user_id = a.user_id and l.angle_issue = a.angle_issue "+userDisplayNameJoin+" where a.guild_id = ? and a.game = ?", guildId, game, guildId, game)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s ratings of guild %s: %w", game, guildId, err)
+	}
+	defer rows.Close()
+
+	ratings := []Rating{}
+	for rows.Next() {
+		rating := Rating{}
+		err = rows.Scan(&rating.Id, &rating.User, &rating.Rating, &rating.Matches)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rating)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(ratings, func(a Rating, b Rating) int {
+		if a.Rating != b.Rating {
+			if a.Rating > b.Rating {
+				return -1
+			}
+			return 1
+		}
+		if a.Matches != b.Matches {
+			return b.Matches - a.Matches
+		}
+		return strings.Compare(a.User, b.User)
+	})
+	return ratings, nil
+}
+
+func GetRatingStandings(guildId string, game string) (string, error) {
+	ratings, err := store.GetRatings(guildId, game)
+	if err != nil {
+		return "", err
+	}
+	if len(ratings) == 0 {
+		return "Nobody has a rating yet, it takes an issue played by two or more people", nil
+	}
+
+	longestUsername := 0
+	for _, rating := range ratings {
+		longestUsername = max(longestUsername, len(rating.User))
+	}
+
+	message := "Rating\n"
+	for pos, rating := range ratings {
+		message += fmt.Sprintf("%2d. %*s %4.0f (%d matches)\n", pos+1, longestUsername, rating.User, rating.Rating, rating.Matches)
+	}
+	return message, nil
+}
+
+func GetRatingMessage(message string, guildId string, userId string) string {
+	game, _ := parseCommandArgs(message)
+	ratings, err := store.GetRatings(guildId, game)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the ratings, try again"
+	}
+
+	for pos, rating := range ratings {
+		if rating.Id == userId {
+			return fmt.Sprintf("%s: %.0f %s rating, #%d of %d after %d matches", rating.User, rating.Rating, game, pos+1, len(ratings), rating.Matches)
+		}
+	}
+	return fmt.Sprintf("%s has no %s rating yet, everyone starts at %.0f after playing an issue someone else played too", GetDisplayName(guildId, userId), game, InitialRating)
+}
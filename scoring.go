@@ -0,0 +1,329 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const AuditScoringChange = "scoring_change"
+
+// ScoringRules are the points a guild gives for each result.
+type ScoringRules struct {
+	// TriesPoints has the points for a result in 1, 2, ... tries, results
+	// that took more tries than listed get the last value
+	TriesPoints []int `json:"tries_points"`
+	FailPoints  int   `json:"fail_points"`
+	// WinBonus is added to every completed result
+	WinBonus int `json:"win_bonus,omitempty"`
+	// A fail whose final guess was less than CloseRange degrees off gets up
+	// to ClosePoints more, the closer the more points
+	ClosePoints int `json:"close_points,omitempty"`
+	CloseRange  int `json:"close_range,omitempty"`
+	// An issue a registered participant didn't play scores MissPoints, or a
+	// fail with MissAsFail
+	MissPoints int  `json:"miss_points,omitempty"`
+	MissAsFail bool `json:"miss_as_fail,omitempty"`
+}
+
+// Closeness is off unless a guild sets its points, this is the range it uses
+// when it doesn't set one.
+const defaultCloseRange = 90
+
+var DefaultScoringRules = ScoringRules{TriesPoints: []int{100, 50, 30, 15}, FailPoints: 5}
+
+// ScoringRuleSet is a version of the rules of a guild, it applies from
+// FromSeason until the season of the next version.
+type ScoringRuleSet struct {
+	FromSeason int
+	Rules      ScoringRules
+}
+
+func calculateEntryScore(rules ScoringRules, tries int, completed int, offBy int) int {
+	if completed == 0 {
+		return rules.FailPoints + calculateClosenessScore(rules, offBy)
+	}
+	if len(rules.TriesPoints) == 0 {
+		return rules.WinBonus
+	}
+
+	tries = min(max(tries, 1), len(rules.TriesPoints))
+	return rules.TriesPoints[tries-1] + rules.WinBonus
+}
+
+// calculateClosenessScore gives partial credit for how close a fail was. Only
+// games that say how far off a guess was have an offBy, it's 0 otherwise.
+func calculateClosenessScore(rules ScoringRules, offBy int) int {
+	closeRange := rules.CloseRange
+	if closeRange <= 0 {
+		closeRange = defaultCloseRange
+	}
+	if rules.ClosePoints == 0 || offBy <= 0 || offBy >= closeRange {
+		return 0
+	}
+	return rules.ClosePoints * (closeRange - offBy) / closeRange
+}
+
+// closenessScoreSQL is calculateClosenessScore added up over the entries of a
+// group in SQL, with the rules of the season of the group. Scoring closeness
+// in SQL keeps the results from being grouped by how far off they were.
+func closenessScoreSQL(ruleSets []ScoringRuleSet) (string, []any) {
+	// The default rules don't score closeness
+	if len(ruleSets) == 0 {
+		return "0", nil
+	}
+	query := "case"
+	args := []any{}
+	for i := len(ruleSets) - 1; i >= 0; i-- {
+		rules := ruleSets[i].Rules
+		if rules.ClosePoints == 0 {
+			query += " when season >= ? then 0"
+			args = append(args, ruleSets[i].FromSeason)
+			continue
+		}
+		closeRange := rules.CloseRange
+		if closeRange <= 0 {
+			closeRange = defaultCloseRange
+		}
+		query += " when season >= ? then sum(case when completed = 0 and off_by > 0 and off_by < ? then ? * (? - off_by) / ? else 0 end)"
+		args = append(args, ruleSets[i].FromSeason, closeRange, rules.ClosePoints, closeRange, closeRange)
+	}
+	return query + " else 0 end", args
+}
+
+// calculateMissScore returns the score of a missed issue, false when the
+// rules don't score them.
+func calculateMissScore(rules ScoringRules) (int, bool) {
+	if rules.MissAsFail {
+		return rules.FailPoints, true
+	}
+	return rules.MissPoints, rules.MissPoints != 0
+}
+
+// rulesForSeason returns the rules a season was played under, ruleSets are
+// sorted by FromSeason.
+func rulesForSeason(ruleSets []ScoringRuleSet, season int) ScoringRules {
+	rules := DefaultScoringRules
+	for _, ruleSet := range ruleSets {
+		if ruleSet.FromSeason > season {
+			break
+		}
+		rules = ruleSet.Rules
+	}
+	return rules
+}
+
+func (rules ScoringRules) String() string {
+	points := []string{}
+	for i, triesPoints := range rules.TriesPoints {
+		tries := strconv.Itoa(i + 1)
+		if i == len(rules.TriesPoints)-1 {
+			tries += "+"
+		}
+		points = append(points, fmt.Sprintf("%s: %d", tries, triesPoints))
+	}
+	text := fmt.Sprintf("tries %s, fail %d", strings.Join(points, ", "), rules.FailPoints)
+	if rules.WinBonus != 0 {
+		text += fmt.Sprintf(", win bonus %d", rules.WinBonus)
+	}
+	if rules.ClosePoints != 0 {
+		closeRange := rules.CloseRange
+		if closeRange <= 0 {
+			closeRange = defaultCloseRange
+		}
+		text += fmt.Sprintf(", up to %d for a fail within %d°", rules.ClosePoints, closeRange)
+	}
+	if rules.MissAsFail {
+		text += ", a missed issue is a fail"
+	} else if rules.MissPoints != 0 {
+		text += fmt.Sprintf(", missed issue %d", rules.MissPoints)
+	}
+	return text
+}
+
+// ListScoringRules returns every version of the rules of a guild, oldest
+// first.
+func (st *Store) ListScoringRules(guildId string) ([]ScoringRuleSet, error) {
+	rows, err := st.db.Query("select from_season, rules from scoring_rules where guild_id = ? order by from_season", guildId)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading scoring rules of guild %s: %w", guildId, err)
+	}
+	defer rows.Close()
+
+	ruleSets := []ScoringRuleSet{}
+	for rows.Next() {
+		ruleSet := ScoringRuleSet{}
+		var rules string
+		err = rows.Scan(&ruleSet.FromSeason, &rules)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(rules), &ruleSet.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid scoring rules of guild %s from season %d: %w", guildId, ruleSet.FromSeason, err)
+		}
+		ruleSets = append(ruleSets, ruleSet)
+	}
+	return ruleSets, rows.Err()
+}
+
+// SaveScoringRules sets the rules of a guild from a season on, later versions
+// are kept.
+func (st *Store) SaveScoringRules(guildId string, fromSeason int, rules ScoringRules, actorId string) error {
+	value, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before := ""
+	err = tx.QueryRow("select rules from scoring_rules where guild_id = ? and from_season = ?", guildId, fromSeason).Scan(&before)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("Error reading scoring rules of guild %s: %w", guildId, err)
+	}
+
+	_, err = tx.Exec("insert into scoring_rules(guild_id, from_season, rules) values(?, ?, ?) on conflict(guild_id, from_season) do update set rules = excluded.rules", guildId, fromSeason, string(value))
+	if err != nil {
+		return fmt.Errorf("Error saving scoring rules of guild %s: %w", guildId, err)
+	}
+
+	err = insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: actorId, Action: AuditScoringChange, TargetId: strconv.Itoa(fromSeason), Before: before, After: string(value)})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// parseScoringRules changes the rules with the "name value" pairs of a
+// command, like "tries 100,50,30,15 fail 5".
+func parseScoringRules(rules ScoringRules, args []string) (ScoringRules, error) {
+	if len(args)%2 != 0 {
+		return rules, fmt.Errorf("%s requires a value", args[len(args)-1])
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		name, value := args[i], args[i+1]
+		switch name {
+		case "tries":
+			triesPoints := []int{}
+			for _, pointsStr := range strings.Split(value, ",") {
+				points, err := strconv.Atoi(pointsStr)
+				if err != nil {
+					return rules, fmt.Errorf("%s is not a list of points", value)
+				}
+				triesPoints = append(triesPoints, points)
+			}
+			rules.TriesPoints = triesPoints
+		case "miss":
+			rules.MissPoints = 0
+			rules.MissAsFail = false
+			switch value {
+			case "off":
+			case "fail":
+				rules.MissAsFail = true
+			default:
+				points, err := strconv.Atoi(value)
+				if err != nil {
+					return rules, fmt.Errorf("%s is not a number, fail or off", value)
+				}
+				rules.MissPoints = points
+			}
+		case "fail", "bonus", "close", "range":
+			points, err := strconv.Atoi(value)
+			if err != nil {
+				return rules, fmt.Errorf("%s is not a number", value)
+			}
+			switch name {
+			case "fail":
+				rules.FailPoints = points
+			case "bonus":
+				rules.WinBonus = points
+			case "close":
+				rules.ClosePoints = points
+			case "range":
+				if points < 1 || points > 180 {
+					return rules, fmt.Errorf("The range should be between 1 and 180 degrees")
+				}
+				rules.CloseRange = points
+			}
+		default:
+			return rules, fmt.Errorf("Unknown rule %s", name)
+		}
+	}
+	return rules, nil
+}
+
+func GetScoringMessage(s *discordgo.Session, m *discordgo.MessageCreate) string {
+	ruleSets, err := store.ListScoringRules(m.GuildID)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the scoring rules, try again"
+	}
+	current, err := GetCurrentSeason(m.GuildID)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the seasons, try again"
+	}
+	currentSeason := current.Number
+
+	command := strings.Fields(m.Content)
+	if len(command) == 1 {
+		message := fmt.Sprintf(`Season %d is scored with %s
+-------------
+!scoring set [tries 100,50,30,15] [fail 5] [bonus 0] [close 0] [range 90] [miss off|fail|-10] [from season] - Change the rules from this or a later season on
+!scoring reset [from season] - Go back to the default rules`, currentSeason, rulesForSeason(ruleSets, currentSeason))
+		for _, ruleSet := range ruleSets {
+			message += fmt.Sprintf("\nFrom season %d: %s", ruleSet.FromSeason, ruleSet.Rules)
+		}
+		return message
+	}
+
+	if !IsAdmin(s, m.Message) {
+		return "Only admins can change the scoring rules"
+	}
+
+	args := command[2:]
+	fromSeason := currentSeason
+	if len(args) >= 2 && args[len(args)-2] == "from" {
+		fromSeason, err = strconv.Atoi(args[len(args)-1])
+		if err != nil {
+			return fmt.Sprintf("%s is not a valid season!!", args[len(args)-1])
+		}
+		args = args[:len(args)-2]
+	}
+	// Past seasons keep the rules they were played under
+	if fromSeason < currentSeason {
+		return fmt.Sprintf("Season %d is over, rules can only change from season %d on", fromSeason, currentSeason)
+	}
+
+	var rules ScoringRules
+	switch command[1] {
+	case "set":
+		rules, err = parseScoringRules(rulesForSeason(ruleSets, fromSeason), args)
+		if err != nil {
+			return err.Error()
+		}
+	case "reset":
+		rules = DefaultScoringRules
+	default:
+		return fmt.Sprintf("Unknown action %s", command[1])
+	}
+
+	err = store.SaveScoringRules(m.GuildID, fromSeason, rules, m.Author.ID)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't save the scoring rules, try again"
+	}
+	return fmt.Sprintf("From season %d results are scored with %s", fromSeason, rules)
+}
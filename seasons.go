@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const SeasonCadenceSetting = "season_cadence"
+
+// How new seasons are generated once the last one is over
+const (
+	WeeklyCadence   = "weekly"
+	BiweeklyCadence = "biweekly"
+	MonthlyCadence  = "monthly"
+	// Custom seasons are only added by hand with !seasons add
+	CustomCadence = "custom"
+)
+
+var seasonCadences = []string{WeeklyCadence, BiweeklyCadence, MonthlyCadence, CustomCadence}
+
+const AuditSeasonChange = "season_change"
+
+// Season is a range of angle issues, results of other games count for the
+// season of the day their issue came out.
+type Season struct {
+	Number     int
+	Name       string
+	StartIssue int
+	EndIssue   int
+}
+
+func (season Season) String() string {
+	return fmt.Sprintf("%s, #%d to #%d", season.Name, season.StartIssue, season.EndIssue)
+}
+
+func toAngleIssue(game string, issue int) int {
+	gameParser, ok := GetGameParser(game)
+	if !ok || gameParser.Name() == AngleGame {
+		return issue
+	}
+	return angleIssueForDate(gameParser.IssueDate(issue))
+}
+
+// nextSeason returns the season the cadence starts after last.
+func nextSeason(last Season, cadence string) Season {
+	season := Season{Number: last.Number + 1, StartIssue: last.EndIssue + 1}
+	season.Name = fmt.Sprintf("Season %d", season.Number)
+	switch cadence {
+	case WeeklyCadence:
+		season.EndIssue = season.StartIssue + 6
+	case BiweeklyCadence:
+		season.EndIssue = season.StartIssue + 13
+	default:
+		// Until the end of the month it starts in
+		year, month, _ := GetAngleIssueDate(season.StartIssue).Date()
+		season.EndIssue = angleIssueForDate(time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)) - 1
+	}
+	return season
+}
+
+// generateSeasons returns the seasons the cadence adds after seasons until
+// there is one for todayIssue. The first season starts on July 1 2025.
+func generateSeasons(seasons []Season, cadence string, todayIssue int) []Season {
+	last := Season{EndIssue: firstSeasonAngleIssue - 1}
+	if len(seasons) > 0 {
+		last = seasons[len(seasons)-1]
+		if cadence == CustomCadence {
+			return nil
+		}
+	} else if cadence == CustomCadence {
+		cadence = MonthlyCadence
+	}
+
+	generated := []Season{}
+	for last.EndIssue < todayIssue {
+		last = nextSeason(last, cadence)
+		generated = append(generated, last)
+	}
+	return generated
+}
+
+// seasonForIssue returns the number of the season of an issue. Results from
+// before the first season count for it, and a season goes on until the next
+// one starts so results after the last custom season still count for it.
+func seasonForIssue(seasons []Season, game string, issue int) int {
+	if len(seasons) == 0 {
+		return 0
+	}
+	angleIssue := toAngleIssue(game, issue)
+	number := seasons[0].Number
+	for _, season := range seasons {
+		if season.StartIssue > angleIssue {
+			break
+		}
+		number = season.Number
+	}
+	return number
+}
+
+// currentSeason returns the last season that started by todayIssue.
+func currentSeason(seasons []Season, todayIssue int) Season {
+	current := Season{}
+	for _, season := range seasons {
+		if season.StartIssue > todayIssue {
+			break
+		}
+		current = season
+	}
+	return current
+}
+
+func seasonName(seasons []Season, number int) string {
+	for _, season := range seasons {
+		if season.Number == number {
+			return season.Name
+		}
+	}
+	return fmt.Sprintf("Season %d", number)
+}
+
+// addCustomSeason places a season starting after todayIssue, cutting short
+// the season it starts in. It returns the seasons that change.
+func addCustomSeason(seasons []Season, season Season, todayIssue int) ([]Season, error) {
+	if season.StartIssue > season.EndIssue {
+		return nil, fmt.Errorf("A season can't end before it starts")
+	}
+	if season.StartIssue <= todayIssue {
+		return nil, fmt.Errorf("Seasons can only be added from issue #%d on", todayIssue+1)
+	}
+
+	changed := []Season{}
+	for i, existing := range seasons {
+		if existing.EndIssue < season.StartIssue {
+			// The results after the last season counted for it until now
+			if i == len(seasons)-1 && existing.EndIssue < season.StartIssue-1 {
+				existing.EndIssue = season.StartIssue - 1
+				changed = append(changed, existing)
+			}
+			continue
+		}
+		if existing.StartIssue >= season.StartIssue {
+			return nil, fmt.Errorf("It overlaps %s", existing)
+		}
+		existing.EndIssue = season.StartIssue - 1
+		changed = append(changed, existing)
+	}
+
+	season.Number = 1
+	if len(seasons) > 0 {
+		season.Number = seasons[len(seasons)-1].Number + 1
+	}
+	if season.Name == "" {
+		season.Name = fmt.Sprintf("Season %d", season.Number)
+	}
+	return append(changed, season), nil
+}
+
+// ListSeasons returns the seasons of a guild in order, with the ones its
+// cadence generates up to today's issue. Generated seasons are stored along
+// with the first result posted in them. Results stored without a guild use
+// monthly seasons.
+func (st *Store) ListSeasons(guildId string) ([]Season, error) {
+	seasons, cadence, err := readSeasons(st.db, guildId)
+	if err != nil {
+		return nil, err
+	}
+	return append(seasons, generateSeasons(seasons, cadence, GetTodayAngleIssue())...), nil
+}
+
+// readSeasons returns the stored seasons of a guild and its cadence.
+func readSeasons(q queryer, guildId string) ([]Season, string, error) {
+	rows, err := q.Query("select number, name, start_issue, end_issue from seasons where guild_id = ? order by number", guildId)
+	if err != nil {
+		return nil, "", fmt.Errorf("Error reading seasons of guild %s: %w", guildId, err)
+	}
+	defer rows.Close()
+
+	seasons := []Season{}
+	for rows.Next() {
+		season := Season{}
+		err = rows.Scan(&season.Number, &season.Name, &season.StartIssue, &season.EndIssue)
+		if err != nil {
+			return nil, "", err
+		}
+		seasons = append(seasons, season)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+	rows.Close()
+
+	cadence := MonthlyCadence
+	if guildId != "" {
+		cadence, err = getGuildSetting(q, guildId, SeasonCadenceSetting, MonthlyCadence)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return seasons, cadence, nil
+}
+
+// saveGeneratedSeasons stores the seasons the cadence of a guild generates up
+// to today's issue, in the transaction that stores a result. It returns every
+// season of the guild.
+func saveGeneratedSeasons(tx *sqlTx, guildId string) ([]Season, error) {
+	seasons, cadence, err := readSeasons(tx, guildId)
+	if err != nil {
+		return nil, err
+	}
+	generated := generateSeasons(seasons, cadence, GetTodayAngleIssue())
+	if guildId == "" {
+		return append(seasons, generated...), nil
+	}
+
+	// Generated seasons follow the cadence, changing it is what gets audited.
+	// Two results can generate them at once, both generate the same seasons.
+	for _, season := range generated {
+		_, err = tx.Exec("insert into seasons(guild_id, number, name, start_issue, end_issue) values(?, ?, ?, ?, ?) on conflict(guild_id, number) do nothing", guildId, season.Number, season.Name, season.StartIssue, season.EndIssue)
+		if err != nil {
+			return nil, fmt.Errorf("Error saving %s of guild %s: %w", season.Name, guildId, err)
+		}
+	}
+	return append(seasons, generated...), nil
+}
+
+// SetSeasonCadence changes how the seasons of a guild are generated. The
+// seasons the old cadence generated up to today are stored first so they
+// don't change, and a custom last season that is already over ends today
+// since the results posted after it counted for it.
+func (st *Store) SetSeasonCadence(guildId string, cadence string, actorId string) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seasons, err := saveGeneratedSeasons(tx, guildId)
+	if err != nil {
+		return err
+	}
+
+	todayIssue := GetTodayAngleIssue()
+	if len(seasons) > 0 && seasons[len(seasons)-1].EndIssue < todayIssue && cadence != CustomCadence {
+		last := seasons[len(seasons)-1]
+		before := last.String()
+		last.EndIssue = todayIssue
+		_, err = tx.Exec("update seasons set end_issue = ? where guild_id = ? and number = ?", last.EndIssue, guildId, last.Number)
+		if err != nil {
+			return fmt.Errorf("Error saving season %d of guild %s: %w", last.Number, guildId, err)
+		}
+		err = insertAuditLog(tx, AuditEntry{GuildId: guildId, ActorId: actorId, Action: AuditSeasonChange, TargetId: strconv.Itoa(last.Number), Before: before, After: last.String()})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = setGuildSetting(tx, guildId, SeasonCadenceSetting, cadence, actorId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveSeasons adds or changes seasons of a guild by their number. The seasons
+// generated up to today are stored first, the changes are made to the
+// seasons listed with them.
+func (st *Store) SaveSeasons(guildId string, seasons []Season, actorId string) error {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = saveGeneratedSeasons(tx, guildId)
+	if err != nil {
+		return err
+	}
+
+	for _, season := range seasons {
+		before := Season{}
+		err = tx.QueryRow("select number, name, start_issue, end_issue from seasons where guild_id = ? and number = ?", guildId, season.Number).Scan(&before.Number, &before.Name, &before.StartIssue, &before.EndIssue)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("Error reading season %d of guild %s: %w", season.Number, guildId, err)
+		}
+
+		_, err = tx.Exec("insert into seasons(guild_id, number, name, start_issue, end_issue) values(?, ?, ?, ?, ?) on conflict(guild_id, number) do update set name = excluded.name, start_issue = excluded.start_issue, end_issue = excluded.end_issue", guildId, season.Number, season.Name, season.StartIssue, season.EndIssue)
+		if err != nil {
+			return fmt.Errorf("Error saving season %d of guild %s: %w", season.Number, guildId, err)
+		}
+
+		auditEntry := AuditEntry{GuildId: guildId, ActorId: actorId, Action: AuditSeasonChange, TargetId: strconv.Itoa(season.Number), After: season.String()}
+		if before.Number != 0 {
+			auditEntry.Before = before.String()
+		}
+		err = insertAuditLog(tx, auditEntry)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetCurrentSeason returns the season of today's issue in a guild, between
+// custom seasons it's the last one that started.
+func GetCurrentSeason(guildId string) (Season, error) {
+	seasons, err := store.ListSeasons(guildId)
+	if err != nil {
+		return Season{}, err
+	}
+	return currentSeason(seasons, GetTodayAngleIssue()), nil
+}
+
+func GetSeasonsMessage(s *discordgo.Session, m *discordgo.MessageCreate) string {
+	seasons, err := store.ListSeasons(m.GuildID)
+	if err != nil {
+		log.Println(err)
+		return "Couldn't load the seasons, try again"
+	}
+	todayIssue := GetTodayAngleIssue()
+	current := currentSeason(seasons, todayIssue)
+
+	command := strings.Fields(m.Content)
+	if len(command) == 1 {
+		cadence := GetSeasonCadence(m.GuildID)
+		currentDescription := current.String()
+		if current.EndIssue < todayIssue {
+			currentDescription += ", it goes on until the next season is added"
+		}
+		message := fmt.Sprintf(`We are on %s, new seasons are %s
+-------------
+!seasons cadence <%s> - How new seasons are made once the last one is over
+!seasons add <start issue> <end issue> [name] - Add a season, it cuts short the one it starts in
+!seasons rename <season> <name> - Change the name of a season
+!seasons recompute - Set the season of every result in this server from the day of its issue`, currentDescription, cadence, strings.Join(seasonCadences, "|"))
+		for _, season := range seasons {
+			if season.StartIssue > todayIssue {
+				message += fmt.Sprintf("\nUpcoming: %s", season)
+			}
+		}
+		return message
+	}
+
+	if !IsAdmin(s, m.Message) {
+		return "Only admins can change seasons"
+	}
+
+	switch command[1] {
+	case "cadence":
+		if len(command) < 3 || !slices.Contains(seasonCadences, command[2]) {
+			return fmt.Sprintf("The cadence should be one of %s", strings.Join(seasonCadences, ", "))
+		}
+		err = store.SetSeasonCadence(m.GuildID, command[2], m.Author.ID)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't save the cadence, try again"
+		}
+		return fmt.Sprintf("Seasons after %s are %s", current.Name, command[2])
+	case "add":
+		if len(command) < 4 {
+			return "!seasons add requires the start and end issues"
+		}
+		startIssue, startErr := strconv.Atoi(strings.TrimPrefix(command[2], "#"))
+		endIssue, endErr := strconv.Atoi(strings.TrimPrefix(command[3], "#"))
+		if startErr != nil || endErr != nil {
+			return fmt.Sprintf("%s and %s should be issue numbers", command[2], command[3])
+		}
+		changed, err := addCustomSeason(seasons, Season{Name: strings.Join(command[4:], " "), StartIssue: startIssue, EndIssue: endIssue}, todayIssue)
+		if err != nil {
+			return err.Error()
+		}
+		err = store.SaveSeasons(m.GuildID, changed, m.Author.ID)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't save the season, try again"
+		}
+		return fmt.Sprintf("Added %s", changed[len(changed)-1])
+	case "rename":
+		if len(command) < 4 {
+			return "!seasons rename requires the season and its new name"
+		}
+		number, err := strconv.Atoi(command[2])
+		if err != nil {
+			return fmt.Sprintf("%s is not a valid season!!", command[2])
+		}
+		index := slices.IndexFunc(seasons, func(season Season) bool { return season.Number == number })
+		if index < 0 {
+			return fmt.Sprintf("There is no season %d", number)
+		}
+		season := seasons[index]
+		season.Name = strings.Join(command[3:], " ")
+		err = store.SaveSeasons(m.GuildID, []Season{season}, m.Author.ID)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't save the season, try again"
+		}
+		return fmt.Sprintf("Season %d is now %s", number, season.Name)
+	case "recompute":
+		changed, err := store.RecomputeSeasons(m.GuildID, m.Author.ID)
+		if err != nil {
+			log.Println(err)
+			return "Couldn't recompute the seasons, try again"
+		}
+		log.Printf("Recomputed seasons, %d entries changed", changed)
+		return fmt.Sprintf("Changed the season of %d results", changed)
+	}
+	return fmt.Sprintf("Unknown action %s", command[1])
+}
+
+func GetSeasonCadence(guildId string) string {
+	cadence, err := store.GetGuildSetting(guildId, SeasonCadenceSetting, MonthlyCadence)
+	if err != nil {
+		log.Println(err)
+		return MonthlyCadence
+	}
+	return cadence
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var sqliteDialect = dialect{
+	Name:         "sqlite",
+	ColumnsQuery: "select name from pragma_table_info(?)",
+}
+
+// Pragmas set on every connection unless the DSN already sets them
+var sqlitePragmas = map[string]string{
+	"_journal_mode": "WAL",
+	"_busy_timeout": "5000",
+	"_foreign_keys": "on",
+}
+
+type SQLiteStore struct {
+	*Store
+}
+
+func OpenSQLiteStore(dsn string) (*SQLiteStore, error) {
+	st, err := openStore("sqlite3", sqliteDSN(dsn), sqliteDialect)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{Store: st}, nil
+}
+
+// BackupTo writes a consistent snapshot of the database to a new file, it can
+// run while the bot keeps writing.
+func (st *SQLiteStore) BackupTo(path string) error {
+	_, err := st.db.Exec("VACUUM INTO ?", path)
+	if err != nil {
+		return fmt.Errorf("Error writing backup %s: %w", path, err)
+	}
+	return nil
+}
+
+// Writes that still find the database locked after the busy timeout are tried
+// again a few times before giving up
+const busyRetries = 3
+
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// retryBusy runs fn again, waiting a bit longer each time, while it fails
+// because SQLite is busy.
+func retryBusy(fn func() error) error {
+	err := fn()
+	for retry := 1; retry <= busyRetries && isBusyError(err); retry++ {
+		log.Printf("Database is busy, retrying (%d/%d): %v", retry, busyRetries, err)
+		time.Sleep(time.Duration(retry) * 200 * time.Millisecond)
+		err = fn()
+	}
+	return err
+}
+
+func sqliteDSN(dsn string) string {
+	path, query, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return dsn
+	}
+	for pragma, value := range sqlitePragmas {
+		if !params.Has(pragma) {
+			params.Set(pragma, value)
+		}
+	}
+	return path + "?" + params.Encode()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repository is everything the bot reads and writes, each storage backend
+// implements it.
+type Repository interface {
+	Close() error
+	Migrate() (int, error)
+	MigrationStatus() ([]MigrationStatus, error)
+
+	InsertAngleTryEntry(angleEntry AngleEntry) error
+	SubmitAngleTryEntry(angleEntry AngleEntry, policy string) (AngleEntry, bool, error)
+	UpdateAngleTryEntry(angleEntry AngleEntry) error
+	RetractAngleTryEntry(entryId string, actorId string) error
+	GetAngleEntryByMessageId(messageId string) (AngleEntry, error)
+	GetAngleEntryForIssue(guildId string, userId string, game string, angleIssue int) (AngleEntry, error)
+	AngleEntryMessageExists(messageId string) (bool, error)
+	ListAngleEntriesByChannel(channelId string) ([]AngleEntry, error)
+	ListAngleEntriesSubmittedBetween(guildId string, start time.Time, end time.Time) ([]AngleEntry, error)
+	ListAngleGuesses(entryId string) ([]AngleGuess, error)
+	RecomputeSeasons(guildId string, actorId string) (int, error)
+	RecomputeAllSeasons() (int, error)
+	GetUsersIds(guildId string, game string) ([]string, error)
+	GetUserIdsAngleIssueDone(guildId string, game string, angleIssue int) ([]string, error)
+	AssignGuild(guildId string) (int, error)
+
+	GetScores(guildId string, game string, season int, allSeasons bool) ([]Score, error)
+	GetStats(guildId string, userId string, game string, season int, allSeasons bool) (string, error)
+	CountOneGuessEntries(guildId string, userId string) (int, error)
+	GetRatings(guildId string, game string) ([]Rating, error)
+	JoinParticipant(guildId string, game string, userId string, issue int) (bool, error)
+	LeaveParticipant(guildId string, game string, userId string, issue int) (bool, error)
+	RecomputeRatings(missingOnly bool) (int, error)
+
+	InsertFailQuote(guildId string, failQuote string, actorId string) error
+	RemoveFailQuote(failQuoteId string, guildId string, actorId string) error
+	ListAuditLog(guildId string, limit int, offset int) ([]AuditEntry, error)
+	ListFailQuotes(guildId string) ([]QuoteEntry, error)
+
+	SaveUser(user UserEntry) error
+	GetUserDisplayName(guildId string, userId string) (string, error)
+
+	ListSeasons(guildId string) ([]Season, error)
+	SaveSeasons(guildId string, seasons []Season, actorId string) error
+	SetSeasonCadence(guildId string, cadence string, actorId string) error
+
+	ListScoringRules(guildId string) ([]ScoringRuleSet, error)
+	SaveScoringRules(guildId string, fromSeason int, rules ScoringRules, actorId string) error
+
+	GetGuildSetting(guildId string, key string, defaultValue string) (string, error)
+	SetGuildSetting(guildId string, key string, value string, actorId string) error
+	ListGuildSettings(key string) (map[string]string, error)
+}
+
+// OpenStore picks the backend from the scheme of the DSN, postgres:// DSNs
+// use Postgres and anything else is a SQLite path or DSN.
+func OpenStore(dsn string) (Repository, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return OpenPostgresStore(dsn)
+	}
+	return OpenSQLiteStore(dsn)
+}
+
+// Store implements the queries shared by the SQL backends, it owns the
+// connection to the database which is opened once when the bot starts.
+type Store struct {
+	db *sqlConn
+}
+
+func openStore(driver string, dsn string, d dialect) (*Store, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error opening %s database: %w", d.Name, err)
+	}
+	return &Store{db: &sqlConn{DB: db, dialect: d}}, nil
+}
+
+func (st *Store) Close() error {
+	return st.db.Close()
+}
+
+// dialect has what changes in the SQL of each backend
+type dialect struct {
+	Name string
+	// NumberedPlaceholders drivers take $1, $2... instead of ?
+	NumberedPlaceholders bool
+	// ColumnsQuery lists the column names of a table
+	ColumnsQuery string
+}
+
+// rebind turns the ? placeholders of a query into the ones of the dialect
+func (d dialect) rebind(query string) string {
+	if !d.NumberedPlaceholders {
+		return query
+	}
+
+	var builder strings.Builder
+	inString := false
+	placeholder := 0
+	for _, r := range query {
+		if r == '\'' {
+			inString = !inString
+		}
+		if r == '?' && !inString {
+			placeholder++
+			builder.WriteString("$" + strconv.Itoa(placeholder))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// sqlConn and sqlTx rebind every query so they can be written once with ?
+// placeholders
+type sqlConn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c *sqlConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *sqlConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *sqlConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *sqlConn) Prepare(query string) (*sql.Stmt, error) {
+	return c.DB.Prepare(c.dialect.rebind(query))
+}
+
+func (c *sqlConn) Begin() (*sqlTx, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, dialect: c.dialect}, nil
+}
+
+// queryer reads from the connection or from a transaction
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type sqlTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (tx *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(tx.dialect.rebind(query))
+}
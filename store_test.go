@@ -0,0 +1,536 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The suite runs against Postgres too when this points to a database the
+// tests can create schemas in, like postgres://angler@localhost/angler_test
+const postgresTestDsnEnv = "ANGLER_TEST_POSTGRES_DSN"
+
+type storeTest struct {
+	name string
+	run  func(t *testing.T, st Repository)
+}
+
+var storeTests = []storeTest{
+	{"insert and read entries", testInsertAngleEntry},
+	{"update entry", testUpdateAngleEntry},
+	{"retract entry", testRetractAngleEntry},
+	{"duplicate policies", testDuplicatePolicies},
+	{"one entry per issue", testUniqueEntry},
+	{"audit fail quotes and settings", testAuditLog},
+	{"seasons", testSeasons},
+	{"season edits", testSeasonEdits},
+	{"recompute seasons", testRecomputeSeasons},
+	{"assign guild", testAssignGuild},
+}
+
+func TestRepository(t *testing.T) {
+	backends := map[string]func(t *testing.T) Repository{"sqlite": openSQLiteTestStore}
+	if os.Getenv(postgresTestDsnEnv) != "" {
+		backends["postgres"] = openPostgresTestStore
+	}
+
+	for backend, open := range backends {
+		for _, test := range storeTests {
+			t.Run(backend+"/"+test.name, func(t *testing.T) {
+				st := open(t)
+				if _, err := st.Migrate(); err != nil {
+					t.Fatal(err)
+				}
+				// The duplicate policy and display names are read through the
+				// global store
+				previous := store
+				store = st
+				t.Cleanup(func() { store = previous })
+				test.run(t, st)
+			})
+		}
+	}
+}
+
+func openSQLiteTestStore(t *testing.T) Repository {
+	st, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "angler.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// openPostgresTestStore gives every test an empty schema of its own.
+func openPostgresTestStore(t *testing.T) Repository {
+	dsn := os.Getenv(postgresTestDsnEnv)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	schema := fmt.Sprintf("angler_test_%d", time.Now().UnixNano())
+	if _, err = db.Exec("create schema " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, err := sql.Open("postgres", dsn)
+		if err == nil {
+			db.Exec("drop schema " + schema + " cascade")
+			db.Close()
+		}
+	})
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	st, err := OpenPostgresStore(dsn + separator + "search_path=" + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// sqlStore returns the queries shared by the backends, for checks the
+// Repository doesn't expose.
+func sqlStore(st Repository) *Store {
+	switch st := st.(type) {
+	case *SQLiteStore:
+		return st.Store
+	case *PostgresStore:
+		return st.Store
+	}
+	return nil
+}
+
+func testAngleEntry(messageId string, tries int, completed int) AngleEntry {
+	return AngleEntry{
+		MessageId:   messageId,
+		GuildId:     "guild",
+		ChannelId:   "channel",
+		SubmittedAt: time.Unix(1751371200, 0),
+		Game:        AngleGame,
+		UserId:      "user",
+		GlobalName:  "User",
+		AngleIssue:  1106,
+		Tries:       tries,
+		OffBy:       0,
+		Completed:   completed,
+		Guesses:     []AngleGuess{{Number: 1, Direction: GuessUp, OffBy: -1}, {Number: 2, Direction: GuessHit, OffBy: 0}},
+	}
+}
+
+// auditActions lists the actions of the audit log of a guild, oldest first.
+func auditActions(t *testing.T, st Repository, guildId string) []string {
+	auditEntries, err := st.ListAuditLog(guildId, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for i := len(auditEntries) - 1; i >= 0; i-- {
+		actions = append(actions, auditEntries[i].Action)
+	}
+	return actions
+}
+
+func testInsertAngleEntry(t *testing.T, st Repository) {
+	angleEntry := testAngleEntry("message", 2, 1)
+	if err := st.InsertAngleTryEntry(angleEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := st.GetAngleEntryByMessageId("message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	angleEntry.Id = stored.Id
+	if !reflect.DeepEqual(stored, angleEntry) {
+		t.Errorf("stored entry is %+v, want %+v", stored, angleEntry)
+	}
+
+	listed, err := st.ListAngleEntriesByChannel("channel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || !reflect.DeepEqual(listed[0], angleEntry) {
+		t.Errorf("entries of the channel are %+v, want %+v", listed, angleEntry)
+	}
+
+	if actions := auditActions(t, st, "guild"); !reflect.DeepEqual(actions, []string{AuditEntryInsert}) {
+		t.Errorf("audit log is %v", actions)
+	}
+}
+
+func testUpdateAngleEntry(t *testing.T, st Repository) {
+	if err := st.InsertAngleTryEntry(testAngleEntry("message", 2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	angleEntry, err := st.GetAngleEntryByMessageId("message")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	angleEntry.Tries = 1
+	angleEntry.Guesses = []AngleGuess{{Number: 1, Direction: GuessHit, OffBy: 0}}
+	if err = st.UpdateAngleTryEntry(angleEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := st.GetAngleEntryByMessageId("message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, angleEntry) {
+		t.Errorf("updated entry is %+v, want %+v", updated, angleEntry)
+	}
+
+	auditEntries, err := st.ListAuditLog("guild", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auditEntries) != 1 || auditEntries[0].Action != AuditEntryUpdate || auditEntries[0].TargetId != angleEntry.Id || auditEntries[0].Before == auditEntries[0].After {
+		t.Errorf("last audit entry is %+v, want the update of %s", auditEntries, angleEntry.Id)
+	}
+}
+
+func testRetractAngleEntry(t *testing.T, st Repository) {
+	if err := st.InsertAngleTryEntry(testAngleEntry("message", 2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	angleEntry, err := st.GetAngleEntryByMessageId("message")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = st.RetractAngleTryEntry(angleEntry.Id, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	// Retracting twice leaves the first retraction alone
+	if err = st.RetractAngleTryEntry(angleEntry.Id, "moderator"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = st.GetAngleEntryByMessageId("message")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("reading a retracted entry returned %v, want sql.ErrNoRows", err)
+	}
+	exists, err := st.AngleEntryMessageExists("message")
+	if err != nil || !exists {
+		t.Errorf("retracted entry exists = %v, %v, want it kept", exists, err)
+	}
+	scores, err := st.GetScores("guild", AngleGame, 1, true)
+	if err != nil || len(scores) != 0 {
+		t.Errorf("scores with a retracted entry are %+v, %v, want none", scores, err)
+	}
+
+	if actions := auditActions(t, st, "guild"); !reflect.DeepEqual(actions, []string{AuditEntryInsert, AuditEntryRetract}) {
+		t.Errorf("audit log is %v", actions)
+	}
+
+	// A new result can be posted for the issue once the old one is retracted
+	if err = st.InsertAngleTryEntry(testAngleEntry("another message", 3, 1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func testDuplicatePolicies(t *testing.T, st Repository) {
+	tests := []struct {
+		policy    string
+		tries     int
+		stored    bool
+		keptTries int
+	}{
+		{KeepFirstPolicy, 1, false, 3},
+		{RejectPolicy, 1, false, 3},
+		{KeepBestPolicy, 4, false, 3},
+		{KeepBestPolicy, 3, false, 3},
+		{KeepBestPolicy, 1, true, 1},
+	}
+
+	for i, test := range tests {
+		guildId := fmt.Sprintf("guild %d", i)
+		if err := st.SetGuildSetting(guildId, DuplicatePolicySetting, test.policy, "admin"); err != nil {
+			t.Fatal(err)
+		}
+
+		first := testAngleEntry("", 3, 1)
+		first.GuildId = guildId
+		stored, reason, err := SubmitAngleEntry(first)
+		if err != nil || !stored || reason != "" {
+			t.Fatalf("%s: first entry stored = %v, %q, %v", test.policy, stored, reason, err)
+		}
+
+		second := testAngleEntry("", test.tries, 1)
+		second.GuildId = guildId
+		stored, reason, err = SubmitAngleEntry(second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != test.stored || (reason == "") != test.stored {
+			t.Errorf("%s with %d tries: stored = %v, %q, want %v", test.policy, test.tries, stored, reason, test.stored)
+		}
+
+		kept, err := st.GetAngleEntryForIssue(guildId, "user", AngleGame, 1106)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kept.Tries != test.keptTries {
+			t.Errorf("%s with %d tries: kept %d tries, want %d", test.policy, test.tries, kept.Tries, test.keptTries)
+		}
+	}
+}
+
+func testUniqueEntry(t *testing.T, st Repository) {
+	if err := st.InsertAngleTryEntry(testAngleEntry("message", 2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	err := st.InsertAngleTryEntry(testAngleEntry("another message", 3, 1))
+	if !isUniqueViolation(err) {
+		t.Errorf("second entry for the issue returned %v, want a unique violation", err)
+	}
+
+	// Editing another result onto the issue
+	nextIssue := testAngleEntry("next message", 3, 1)
+	nextIssue.AngleIssue++
+	if err = st.InsertAngleTryEntry(nextIssue); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := st.GetAngleEntryByMessageId("next message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.AngleIssue--
+	if err = st.UpdateAngleTryEntry(stored); !isUniqueViolation(err) {
+		t.Errorf("editing an entry onto an issue with one returned %v, want a unique violation", err)
+	}
+
+	// Another guild has its own entries
+	otherGuild := testAngleEntry("other message", 3, 1)
+	otherGuild.GuildId = "other guild"
+	if err = st.InsertAngleTryEntry(otherGuild); err != nil {
+		t.Error(err)
+	}
+}
+
+func testAuditLog(t *testing.T, st Repository) {
+	if err := st.InsertFailQuote("guild", "so close", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	failQuotes, err := st.ListFailQuotes("guild")
+	if err != nil || len(failQuotes) != 1 {
+		t.Fatalf("fail quotes are %+v, %v", failQuotes, err)
+	}
+	if err = st.RemoveFailQuote(failQuotes[0].Id, "guild", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.SetGuildSetting("guild", DuplicatePolicySetting, RejectPolicy, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	auditEntries, err := st.ListAuditLog("guild", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for _, auditEntry := range auditEntries {
+		if auditEntry.ActorId != "admin" {
+			t.Errorf("%s was made by %q, want admin", auditEntry.Action, auditEntry.ActorId)
+		}
+		actions = append(actions, auditEntry.Action)
+	}
+	if want := []string{AuditSettingChange, AuditQuoteRemove, AuditQuoteAdd}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("audit log is %v, want %v", actions, want)
+	}
+
+	if others, err := st.ListAuditLog("other guild", 100, 0); err != nil || len(others) != 0 {
+		t.Errorf("audit log of another guild is %+v, %v, want it empty", others, err)
+	}
+}
+
+func testSeasons(t *testing.T, st Repository) {
+	todayIssue := GetTodayAngleIssue()
+	countSeasons := func() int {
+		count := 0
+		err := sqlStore(st).db.QueryRow("select count(*) from seasons").Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	seasons, err := st.ListSeasons("guild")
+	if err != nil || len(seasons) == 0 {
+		t.Fatalf("seasons are %v, %v", seasons, err)
+	}
+	if stored := countSeasons(); stored != 0 {
+		t.Errorf("listing seasons stored %d of them", stored)
+	}
+
+	// Custom seasons that are over keep the results posted after them
+	if err = st.SetGuildSetting("guild", SeasonCadenceSetting, CustomCadence, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlStore(st).db.Exec("insert into seasons(guild_id, number, name, start_issue, end_issue) values(?, ?, ?, ?, ?)", "guild", 1, "Only", firstSeasonAngleIssue, todayIssue-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	angleEntry := testAngleEntry("message", 2, 1)
+	angleEntry.AngleIssue = todayIssue
+	if err = st.InsertAngleTryEntry(angleEntry); err != nil {
+		t.Fatal(err)
+	}
+	if scores, err := st.GetScores("guild", AngleGame, 1, false); err != nil || len(scores) != 1 {
+		t.Errorf("scores of the last custom season are %+v, %v, want the result after it", scores, err)
+	}
+
+	// Going back to monthly seasons starts them after today
+	if err = st.SetSeasonCadence("guild", MonthlyCadence, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	seasons, err = st.ListSeasons("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seasons) != 1 || seasons[0].EndIssue != todayIssue {
+		t.Errorf("seasons are %v, want the custom one to end on #%d", seasons, todayIssue)
+	}
+}
+
+// testSeasonEdits checks adding and renaming seasons keeps the generated
+// seasons before them, stored or not.
+func testSeasonEdits(t *testing.T, st Repository) {
+	todayIssue := GetTodayAngleIssue()
+	generated, err := st.ListSeasons("guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSeasons := func(action string, want []Season) {
+		seasons, err := st.ListSeasons("guild")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(seasons, want) {
+			t.Errorf("seasons after %s are %v, want %v", action, seasons, want)
+		}
+		if season := seasonForIssue(seasons, AngleGame, firstSeasonAngleIssue); season != 1 {
+			t.Errorf("after %s the first issue is in season %d, want 1", action, season)
+		}
+	}
+
+	// Nothing is stored yet, once the cadence is custom only stored seasons
+	// are left
+	renamed := slices.Clone(generated)
+	renamed[len(renamed)-1].Name = "Current"
+	if err = st.SaveSeasons("guild", renamed[len(renamed)-1:], "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.SetGuildSetting("guild", SeasonCadenceSetting, CustomCadence, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	checkSeasons("a rename", renamed)
+
+	added := Season{Name: "Finale", StartIssue: todayIssue + 100, EndIssue: todayIssue + 130}
+	changed, err := addCustomSeason(renamed, added, todayIssue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = st.SaveSeasons("guild", changed, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	want := append(renamed[:len(renamed)-1], changed...)
+	checkSeasons("an add", want)
+}
+
+func testRecomputeSeasons(t *testing.T, st Repository) {
+	for _, guildId := range []string{"guild", "other guild"} {
+		angleEntry := testAngleEntry("message in "+guildId, 2, 1)
+		angleEntry.GuildId = guildId
+		if err := st.InsertAngleTryEntry(angleEntry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sqlStore(st).db.Exec("update angle_tries set season = 5"); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := st.RecomputeSeasons("guild", "admin")
+	if err != nil || changed != 1 {
+		t.Errorf("recomputing the seasons of a guild changed %d entries, %v, want 1", changed, err)
+	}
+	for guildId, want := range map[string]int{"guild": 1, "other guild": 5} {
+		var season int
+		err = sqlStore(st).db.QueryRow("select season from angle_tries where guild_id = ?", guildId).Scan(&season)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if season != want {
+			t.Errorf("entry of %s is in season %d, want %d", guildId, season, want)
+		}
+	}
+
+	if changed, err = st.RecomputeAllSeasons(); err != nil || changed != 1 {
+		t.Errorf("recomputing the seasons of every guild changed %d entries, %v, want 1", changed, err)
+	}
+
+	for _, guildId := range []string{"guild", "other guild"} {
+		if actions := auditActions(t, st, guildId); !reflect.DeepEqual(actions, []string{AuditEntryInsert, AuditSeasonRecompute}) {
+			t.Errorf("audit log of %s is %v", guildId, actions)
+		}
+	}
+}
+
+func testAssignGuild(t *testing.T, st Repository) {
+	angleEntry := testAngleEntry("message", 2, 1)
+	angleEntry.GuildId = ""
+	if err := st.InsertAngleTryEntry(angleEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	assigned, err := st.AssignGuild("guild")
+	if err != nil || assigned != 1 {
+		t.Errorf("assigned %d entries, %v, want 1", assigned, err)
+	}
+	if actions := auditActions(t, st, "guild"); !reflect.DeepEqual(actions, []string{AuditGuildAssign}) {
+		t.Errorf("audit log is %v", actions)
+	}
+
+	// Nothing left to move isn't recorded
+	if _, err = st.AssignGuild("guild"); err != nil {
+		t.Fatal(err)
+	}
+	if actions := auditActions(t, st, "guild"); len(actions) != 1 {
+		t.Errorf("audit log is %v", actions)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query    string
+		sqlite   string
+		postgres string
+	}{
+		{"select 1", "select 1", "select 1"},
+		{"select * from t where a = ? and b = ?", "select * from t where a = ? and b = ?", "select * from t where a = $1 and b = $2"},
+		{"select * from t where a = '?' and b = ?", "select * from t where a = '?' and b = ?", "select * from t where a = '?' and b = $1"},
+		{"insert into t(a, b) values(?, 'it''s ?') returning ?", "insert into t(a, b) values(?, 'it''s ?') returning ?", "insert into t(a, b) values($1, 'it''s ?') returning $2"},
+	}
+
+	for _, test := range tests {
+		if got := sqliteDialect.rebind(test.query); got != test.sqlite {
+			t.Errorf("sqlite rebind(%q) = %q, want %q", test.query, got, test.sqlite)
+		}
+		if got := postgresDialect.rebind(test.query); got != test.postgres {
+			t.Errorf("postgres rebind(%q) = %q, want %q", test.query, got, test.postgres)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// refreshUser saves the names the author of a message goes by, member is nil
+// for messages that don't come from a guild.
+func refreshUser(guildId string, user *discordgo.User, member *discordgo.Member) {
+	userEntry := UserEntry{Id: user.ID, Username: user.Username, GlobalName: user.GlobalName}
+	if member != nil && guildId != "" {
+		userEntry.GuildId = guildId
+		userEntry.Nickname = member.Nick
+	}
+
+	err := store.SaveUser(userEntry)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// GetDisplayName returns how a user is shown in a guild, users that were never
+// seen are shown by their id.
+func GetDisplayName(guildId string, userId string) string {
+	name, err := store.GetUserDisplayName(guildId, userId)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+		}
+		return userId
+	}
+	return name
+}